@@ -1,20 +1,46 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/gjkim42/gomaxprocs-injector/pkg/admission"
+	"github.com/gjkim42/gomaxprocs-injector/pkg/config"
+	"github.com/gjkim42/gomaxprocs-injector/pkg/decisionlog"
+	"github.com/gjkim42/gomaxprocs-injector/pkg/gomaxprocs"
+	"github.com/gjkim42/gomaxprocs-injector/pkg/metrics"
+	"github.com/gjkim42/gomaxprocs-injector/pkg/policy"
+	"github.com/gjkim42/gomaxprocs-injector/pkg/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 )
 
 func main() {
-	checkErr(os.Stderr, NewDefaultGOMAXPROCSInjectorCommand().Execute())
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	checkErr(os.Stderr, NewDefaultGOMAXPROCSInjectorCommand().ExecuteContext(ctx))
 }
 
 func checkErr(w io.Writer, err error) {
@@ -24,59 +50,490 @@ func checkErr(w io.Writer, err error) {
 	}
 }
 
+// policyFlags are the flags that set the policy, which the configuration file
+// replaces.
+var policyFlags = []string{
+	"injection-mode",
+	"mode",
+	"inject-gomaxprocs",
+	"enforce",
+	"strategy",
+	"strategy-tolerance",
+	"min-gomaxprocs",
+	"max-gomaxprocs",
+	"request-fallback",
+	"request-fallback-burst-multiplier",
+	"request-fallback-min",
+	"request-fallback-max",
+	"inject-gomemlimit",
+	"gomemlimit-percent",
+	"init-containers",
+	"decision-warnings",
+	"resolve-env-from",
+	"env-from-error-policy",
+	"validation-action",
+	"workload-kinds",
+}
+
+// toConfig builds the policy from the policy flags.
+func (flags *GOMAXPROCSInjectorFlags) toConfig() (admission.Config, error) {
+	strategy, err := gomaxprocs.New(flags.Strategy, flags.StrategyTolerance)
+	if err != nil {
+		return admission.Config{}, err
+	}
+
+	config := admission.DefaultConfig()
+	config.Version = "flags"
+	config.InjectionMode = admission.InjectionMode(flags.InjectionMode)
+	config.Mode = admission.Mode(flags.Mode)
+	config.GOMAXPROCSEnabled = flags.InjectGOMAXPROCS
+	config.Enforce = flags.Enforce
+	config.Strategy = strategy
+	config.Min = flags.MinGOMAXPROCS
+	config.Max = flags.MaxGOMAXPROCS
+	config.RequestFallback = admission.RequestFallbackConfig{
+		Enabled:         flags.RequestFallback,
+		BurstMultiplier: flags.RequestFallbackBurstMultiplier,
+		Min:             flags.RequestFallbackMin,
+		Max:             flags.RequestFallbackMax,
+	}
+	config.GOMEMLIMIT = admission.GOMEMLIMITConfig{
+		Enabled: flags.InjectGOMEMLIMIT,
+		Percent: flags.GOMEMLIMITPercent,
+	}
+	config.InitContainers = admission.InitContainerPolicy(flags.InitContainers)
+	config.DecisionWarnings = flags.DecisionWarnings
+	config.ResolveEnvFrom = flags.ResolveEnvFrom
+	config.EnvFromErrorPolicy = admission.EnvFromErrorPolicy(flags.EnvFromErrorPolicy)
+	config.ValidationAction = admission.ValidationAction(flags.ValidationAction)
+	config.WorkloadKinds = flags.WorkloadKinds
+
+	return config, config.Validate()
+}
+
+// GOMAXPROCSInjectorFlags holds the raw command line flags.
+type GOMAXPROCSInjectorFlags struct {
+	CertFile          string
+	KeyFile           string
+	BindAddress       string
+	Port              int
+	MetricsPort       int
+	InjectionMode     string
+	Mode              string
+	InjectGOMAXPROCS  bool
+	Enforce           bool
+	Strategy          string
+	StrategyTolerance int64
+	MinGOMAXPROCS     int64
+	MaxGOMAXPROCS     int64
+
+	RequestFallback                bool
+	RequestFallbackBurstMultiplier float64
+	RequestFallbackMin             int64
+	RequestFallbackMax             int64
+
+	InjectGOMEMLIMIT  bool
+	GOMEMLIMITPercent int64
+
+	InitContainers string
+
+	DecisionWarnings bool
+
+	Kubeconfig         string
+	ResolveEnvFrom     bool
+	EnvFromErrorPolicy string
+
+	ValidationAction string
+
+	Config               string
+	ConfigReloadInterval time.Duration
+
+	EnablePolicies       bool
+	PolicyStatusInterval time.Duration
+
+	WorkloadKinds []string
+
+	TracingEndpoint      string
+	TracingInsecure      bool
+	TracingSamplingRatio float64
+
+	DecisionLog                 string
+	DecisionLogMaxSize          int
+	DecisionLogMaxBackups       int
+	DecisionLogSampleInitial    int
+	DecisionLogSampleThereafter int
+
+	EmitEvents bool
+
+	DebugPort       int
+	RecentDecisions int
+}
+
 func NewDefaultGOMAXPROCSInjectorCommand() *cobra.Command {
 	options := &GOMAXPROCSInjectorOptions{}
-	certFile := "tls.crt"
-	keyFile := "tls.key"
-	bindAddress := "0.0.0.0"
-	port := 443
+	flags := &GOMAXPROCSInjectorFlags{
+		CertFile:         "tls.crt",
+		KeyFile:          "tls.key",
+		BindAddress:      "0.0.0.0",
+		Port:             443,
+		MetricsPort:      8080,
+		InjectionMode:    string(admission.InjectionModeOptOut),
+		Mode:             string(admission.ModeMutate),
+		InjectGOMAXPROCS: true,
+		Strategy:         gomaxprocs.StrategyFloor,
+
+		RequestFallbackBurstMultiplier: 1,
+
+		GOMEMLIMITPercent: 90,
+
+		InitContainers: string(admission.InitContainerPolicyInject),
+
+		EnvFromErrorPolicy: string(admission.EnvFromErrorPolicySkip),
+
+		ValidationAction: string(admission.ValidationActionWarn),
+
+		ConfigReloadInterval: 10 * time.Second,
+
+		PolicyStatusInterval: time.Minute,
+
+		TracingSamplingRatio: 1,
+
+		DecisionLogMaxSize:          100,
+		DecisionLogMaxBackups:       3,
+		DecisionLogSampleInitial:    100,
+		DecisionLogSampleThereafter: 100,
+
+		EmitEvents: true,
+
+		DebugPort:       8081,
+		RecentDecisions: 256,
+	}
 	cmd := &cobra.Command{
 		Use:   "gomaxprocs-injector",
 		Short: "The admission controller that injects optimized GOMAXPROCS environment variable into pods",
 		Run: func(cmd *cobra.Command, args []string) {
 			klog.InfoS("Starting...")
-			checkErr(os.Stderr, options.Complete(certFile, keyFile, bindAddress, port))
-			checkErr(os.Stderr, options.Run())
+			checkErr(os.Stderr, options.Complete(flags, cmd.Flags().Changed))
+			checkErr(os.Stderr, options.Run(cmd.Context()))
 		},
 	}
 
 	klog.InitFlags(nil)
 	cmd.Flags().AddGoFlagSet(flag.CommandLine)
-	cmd.Flags().StringVar(&certFile, "cert-file", certFile, "File containing the default Certificate for HTTPS.")
-	cmd.Flags().StringVar(&keyFile, "key-file", keyFile, "File containing the default Key for HTTPS.")
-	cmd.Flags().StringVar(&bindAddress, "bind-address", bindAddress, "The address on which to listen for the webhook's server")
-	cmd.Flags().IntVar(&port, "port", port, "The port on which to serve the webhook's server")
+	cmd.Flags().StringVar(&flags.CertFile, "cert-file", flags.CertFile, "File containing the default Certificate for HTTPS.")
+	cmd.Flags().StringVar(&flags.KeyFile, "key-file", flags.KeyFile, "File containing the default Key for HTTPS.")
+	cmd.Flags().StringVar(&flags.BindAddress, "bind-address", flags.BindAddress, "The address on which to listen for the webhook's server")
+	cmd.Flags().IntVar(&flags.Port, "port", flags.Port, "The port on which to serve the webhook's server")
+	cmd.Flags().IntVar(&flags.MetricsPort, "metrics-port", flags.MetricsPort, "The port on which to serve Prometheus metrics over plain HTTP, 0 disables it")
+	cmd.Flags().StringVar(&flags.InjectionMode, "injection-mode", flags.InjectionMode, fmt.Sprintf("%q to mutate pods unless they or their namespace are marked disabled, %q to mutate only pods that are or whose namespace is marked enabled", admission.InjectionModeOptOut, admission.InjectionModeOptIn))
+	cmd.Flags().StringVar(&flags.Mode, "mode", flags.Mode, fmt.Sprintf("%q to apply the changes, %q to only report them in warnings and audit annotations. Namespaces can override it with the gomaxprocs-injector/mode annotation", admission.ModeMutate, admission.ModeReportOnly))
+	cmd.Flags().BoolVar(&flags.InjectGOMAXPROCS, "inject-gomaxprocs", flags.InjectGOMAXPROCS, "Inject GOMAXPROCS into containers with a CPU limit")
+	cmd.Flags().BoolVar(&flags.Enforce, "enforce", flags.Enforce, "Override GOMAXPROCS set by the user if it is not a positive integer or exceeds the computed value")
+	cmd.Flags().StringVar(&flags.Strategy, "strategy", flags.Strategy, fmt.Sprintf("The strategy used to compute GOMAXPROCS from the CPU limit. One of: %s", strings.Join(gomaxprocs.Strategies, ", ")))
+	cmd.Flags().Int64Var(&flags.StrategyTolerance, "strategy-tolerance", flags.StrategyTolerance, fmt.Sprintf("The number of millicores below the next core within which the %q strategy rounds up", gomaxprocs.StrategyFloorTolerance))
+
+	cmd.Flags().Int64Var(&flags.MinGOMAXPROCS, "min-gomaxprocs", flags.MinGOMAXPROCS, "The minimum GOMAXPROCS computed from resources, 0 means no minimum")
+	cmd.Flags().Int64Var(&flags.MaxGOMAXPROCS, "max-gomaxprocs", flags.MaxGOMAXPROCS, "The maximum GOMAXPROCS computed from resources, 0 means no maximum")
+	cmd.Flags().BoolVar(&flags.RequestFallback, "request-fallback", flags.RequestFallback, "Derive GOMAXPROCS from the CPU request of containers that have no CPU limit")
+	cmd.Flags().Float64Var(&flags.RequestFallbackBurstMultiplier, "request-fallback-burst-multiplier", flags.RequestFallbackBurstMultiplier, "The multiplier applied to the CPU request before computing GOMAXPROCS")
+	cmd.Flags().Int64Var(&flags.RequestFallbackMin, "request-fallback-min", flags.RequestFallbackMin, "The minimum GOMAXPROCS derived from a CPU request, 0 means no minimum")
+	cmd.Flags().Int64Var(&flags.RequestFallbackMax, "request-fallback-max", flags.RequestFallbackMax, "The maximum GOMAXPROCS derived from a CPU request, 0 means no maximum")
+	cmd.Flags().BoolVar(&flags.InjectGOMEMLIMIT, "inject-gomemlimit", flags.InjectGOMEMLIMIT, "Inject GOMEMLIMIT into containers with a memory limit")
+	cmd.Flags().Int64Var(&flags.GOMEMLIMITPercent, "gomemlimit-percent", flags.GOMEMLIMITPercent, "The percentage of the memory limit GOMEMLIMIT is set to")
+	cmd.Flags().StringVar(&flags.InitContainers, "init-containers", flags.InitContainers, fmt.Sprintf("Whether regular init containers are mutated, %q or %q. Native sidecar init containers are always mutated", admission.InitContainerPolicyInject, admission.InitContainerPolicySkip))
+	cmd.Flags().BoolVar(&flags.DecisionWarnings, "decision-warnings", flags.DecisionWarnings, "Report what was done with each container as an admission warning, in addition to the audit annotation")
+	cmd.Flags().StringVar(&flags.Kubeconfig, "kubeconfig", flags.Kubeconfig, "Path to a kubeconfig file. The in-cluster configuration is used if empty")
+	cmd.Flags().BoolVar(&flags.ResolveEnvFrom, "resolve-env-from", flags.ResolveEnvFrom, "Look up the ConfigMaps and Secrets referenced by envFrom to detect variables set by the user; requires read access to ConfigMaps and Secrets, granted by the gomaxprocs-injector-env-from ClusterRole")
+	cmd.Flags().StringVar(&flags.EnvFromErrorPolicy, "env-from-error-policy", flags.EnvFromErrorPolicy, fmt.Sprintf("What to do when envFrom sources cannot be looked up, %q to leave the variable unset or %q to inject it anyway", admission.EnvFromErrorPolicySkip, admission.EnvFromErrorPolicyInject))
+	cmd.Flags().StringVar(&flags.ValidationAction, "validation-action", flags.ValidationAction, fmt.Sprintf("What the validating webhook does with pods whose GOMAXPROCS is misconfigured, %q to admit them with warnings or %q to reject them", admission.ValidationActionWarn, admission.ValidationActionDeny))
+	cmd.Flags().StringVar(&flags.Config, "config", flags.Config, "Path to a configuration file. If set, the policy is read from the file, which is reloaded when it changes, and the policy flags must not be set")
+	cmd.Flags().DurationVar(&flags.ConfigReloadInterval, "config-reload-interval", flags.ConfigReloadInterval, "How often the configuration file is checked for changes")
+
+	cmd.Flags().BoolVar(&flags.EnablePolicies, "enable-policies", flags.EnablePolicies, "Apply GOMAXPROCSPolicy and ClusterGOMAXPROCSPolicy resources. Their CustomResourceDefinitions must be installed")
+	cmd.Flags().DurationVar(&flags.PolicyStatusInterval, "policy-status-interval", flags.PolicyStatusInterval, "How often the status of the policies is updated")
+	cmd.Flags().StringVar(&flags.TracingEndpoint, "tracing-endpoint", flags.TracingEndpoint, "The host and port of an OTLP/HTTP receiver to export spans of admission requests to. Tracing is disabled if empty")
+	cmd.Flags().BoolVar(&flags.TracingInsecure, "tracing-insecure", flags.TracingInsecure, "Export spans over plain HTTP instead of HTTPS")
+	cmd.Flags().Float64Var(&flags.TracingSamplingRatio, "tracing-sampling-ratio", flags.TracingSamplingRatio, "The fraction of admission requests that are traced. Requests traced by the API server are always traced")
+	cmd.Flags().StringVar(&flags.DecisionLog, "decision-log", flags.DecisionLog, "File to write one JSON line per admission request to, with the decisions taken and the patch, or \"-\" for stdout. The decision log is disabled if empty")
+	cmd.Flags().IntVar(&flags.DecisionLogMaxSize, "decision-log-max-size", flags.DecisionLogMaxSize, "The size in megabytes at which the decision log file is rotated")
+	cmd.Flags().IntVar(&flags.DecisionLogMaxBackups, "decision-log-max-backups", flags.DecisionLogMaxBackups, "The number of rotated decision log files to keep, 0 keeps them all")
+	cmd.Flags().IntVar(&flags.DecisionLogSampleInitial, "decision-log-sample-initial", flags.DecisionLogSampleInitial, "The number of admission requests written to the decision log every second before sampling starts, 0 disables sampling")
+	cmd.Flags().IntVar(&flags.DecisionLogSampleThereafter, "decision-log-sample-thereafter", flags.DecisionLogSampleThereafter, "Once sampling starts, every how many admission requests one is written to the decision log, 0 drops them all")
+	cmd.Flags().BoolVar(&flags.EmitEvents, "emit-events", flags.EmitEvents, "Emit Events about pods whose GOMAXPROCS is overridden, whose annotations are invalid, or that have no CPU limit in namespaces that ask for it")
+	cmd.Flags().IntVar(&flags.DebugPort, "debug-port", flags.DebugPort, "The port on which to serve the debug endpoints over plain HTTP, on localhost only, 0 disables them")
+	cmd.Flags().IntVar(&flags.RecentDecisions, "recent-decisions", flags.RecentDecisions, "The number of recent admission requests served at /debug/decisions on the debug port, 0 disables the endpoint")
+	cmd.Flags().StringSliceVar(&flags.WorkloadKinds, "workload-kinds", flags.WorkloadKinds, fmt.Sprintf("The kinds of workloads whose pod templates are mutated, in addition to pods. Any of: %s", strings.Join(admission.WorkloadKinds(), ", ")))
 
 	return cmd
 }
 
 type GOMAXPROCSInjectorOptions struct {
-	Address   string
-	TLSConfig *tls.Config
+	Address              string
+	MetricsAddress       string
+	DebugAddress         string
+	TLSConfig            *tls.Config
+	CertificateExpiry    time.Time
+	Config               admission.Config
+	ConfigFile           string
+	ConfigReloadInterval time.Duration
+	Client               kubernetes.Interface
+	EnablePolicies       bool
+	PolicyStatusInterval time.Duration
+	DynamicClient        dynamic.Interface
+	Tracing              *tracing.Options
+	DecisionLog          *decisionlog.Logger
+	EmitEvents           bool
+	RecentDecisions      *decisionlog.Ring
 }
 
-func (o *GOMAXPROCSInjectorOptions) Complete(certFile, keyFile, bindAddress string, port int) error {
-	if certFile != "" && keyFile != "" {
-		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+// Complete builds the options from the flags. changed reports whether a flag
+// was set on the command line.
+func (o *GOMAXPROCSInjectorOptions) Complete(flags *GOMAXPROCSInjectorFlags, changed func(name string) bool) error {
+	if flags.CertFile != "" && flags.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(flags.CertFile, flags.KeyFile)
 		if err != nil {
 			return err
 		}
 		o.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return err
+		}
+		o.CertificateExpiry = leaf.NotAfter
 	}
 
-	o.Address = fmt.Sprintf("%s:%d", bindAddress, port)
+	o.Address = fmt.Sprintf("%s:%d", flags.BindAddress, flags.Port)
+	if flags.MetricsPort != 0 {
+		o.MetricsAddress = fmt.Sprintf("%s:%d", flags.BindAddress, flags.MetricsPort)
+	}
+	// The debug endpoints expose the users who created the pods, so they are
+	// only served on localhost, to be reached through port forwarding.
+	if flags.DebugPort != 0 {
+		o.DebugAddress = fmt.Sprintf("127.0.0.1:%d", flags.DebugPort)
+	}
+
+	var err error
+	if flags.Config != "" {
+		for _, name := range policyFlags {
+			if changed(name) {
+				return fmt.Errorf("--%s cannot be used with --config, set it in the configuration file instead", name)
+			}
+		}
+		o.Config, err = config.Load(flags.Config)
+		if err != nil {
+			return fmt.Errorf("failed to load configuration file %s: %w", flags.Config, err)
+		}
+	} else {
+		o.Config, err = flags.toConfig()
+		if err != nil {
+			return err
+		}
+	}
+	o.ConfigFile = flags.Config
+	o.ConfigReloadInterval = flags.ConfigReloadInterval
+
+	restConfig, err := clientcmd.BuildConfigFromFlags("", flags.Kubeconfig)
+	if err != nil {
+		return err
+	}
+	o.Client, err = kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+
+	if flags.TracingEndpoint != "" {
+		o.Tracing = &tracing.Options{
+			Endpoint:      flags.TracingEndpoint,
+			Insecure:      flags.TracingInsecure,
+			SamplingRatio: flags.TracingSamplingRatio,
+		}
+	}
+
+	if flags.DecisionLog != "" {
+		o.DecisionLog = decisionlog.NewFile(flags.DecisionLog, flags.DecisionLogMaxSize, flags.DecisionLogMaxBackups, decisionlog.Sampling{
+			Initial:    flags.DecisionLogSampleInitial,
+			Thereafter: flags.DecisionLogSampleThereafter,
+		})
+	}
+
+	o.EmitEvents = flags.EmitEvents
+	if o.DebugAddress != "" && flags.RecentDecisions > 0 {
+		o.RecentDecisions = decisionlog.NewRing(flags.RecentDecisions)
+	}
+
+	o.EnablePolicies = flags.EnablePolicies
+	o.PolicyStatusInterval = flags.PolicyStatusInterval
+	if o.EnablePolicies {
+		o.DynamicClient, err = dynamic.NewForConfig(restConfig)
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
-func (o *GOMAXPROCSInjectorOptions) Run() error {
-	http.Handle("/webhook", admission.NewController())
-	http.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) { w.Write([]byte("ok")) })
+func (o *GOMAXPROCSInjectorOptions) Run(ctx context.Context) error {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	m := metrics.New(registry)
+	if !o.CertificateExpiry.IsZero() {
+		m.SetCertificateExpiry(o.CertificateExpiry)
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(o.Client, 0, informers.WithTransform(admission.TrimEnvSourceValues))
+	opts := []admission.Option{
+		admission.WithNamespaceLister(factory.Core().V1().Namespaces().Lister()),
+		admission.WithLimitRangeLister(factory.Core().V1().LimitRanges().Lister()),
+		admission.WithMetrics(m),
+		admission.WithDecisionLog(o.DecisionLog),
+		admission.WithRecentDecisions(o.RecentDecisions),
+	}
+	defer func() {
+		if err := o.DecisionLog.Close(); err != nil {
+			klog.ErrorS(err, "Failed to close decision log")
+		}
+	}()
+	if o.EmitEvents {
+		// The broadcaster rate-limits the Events of each object and
+		// aggregates similar ones, as pods of the same workload share them.
+		broadcaster := record.NewBroadcaster(record.WithContext(ctx))
+		broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: o.Client.CoreV1().Events("")})
+		defer broadcaster.Shutdown()
+		opts = append(opts, admission.WithEventRecorder(broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "gomaxprocs-injector"})))
+	}
+	if o.Tracing != nil {
+		tp, err := tracing.NewTracerProvider(ctx, *o.Tracing)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := tp.Shutdown(context.Background()); err != nil {
+				klog.ErrorS(err, "Failed to shut down tracer provider")
+			}
+		}()
+		opts = append(opts, admission.WithTracerProvider(tp))
+	}
+	// ConfigMaps and Secrets are only watched if envFrom is resolved at
+	// startup, as that takes permissions that are not granted by default.
+	if o.Config.ResolveEnvFrom {
+		opts = append(opts, admission.WithEnvFromListers(
+			factory.Core().V1().ConfigMaps().Lister(),
+			factory.Core().V1().Secrets().Lister(),
+		))
+	}
+	factory.Start(ctx.Done())
+
+	var policySynced []cache.InformerSynced
+	if o.EnablePolicies {
+		policies, clusterPolicies, err := policy.NewInformers(o.DynamicClient, 0)
+		if err != nil {
+			return err
+		}
+		lister := policy.NewLister(policies.GetIndexer(), clusterPolicies.GetIndexer())
+		tracker := policy.NewTracker(o.DynamicClient, lister)
+		opts = append(opts, admission.WithPolicies(lister, tracker))
+		go policies.Run(ctx.Done())
+		go clusterPolicies.Run(ctx.Done())
+		go tracker.Run(ctx, o.PolicyStatusInterval)
+		policySynced = append(policySynced, policies.HasSynced, clusterPolicies.HasSynced)
+	}
+
+	// The webhook is not ready until the caches it reads from have synced.
+	var synced atomic.Bool
+	go func() {
+		for typ, ok := range factory.WaitForCacheSync(ctx.Done()) {
+			if !ok {
+				klog.ErrorS(nil, "Failed to sync informer cache", "type", typ)
+				return
+			}
+		}
+		if !cache.WaitForCacheSync(ctx.Done(), policySynced...) {
+			klog.ErrorS(nil, "Failed to sync policy informer caches")
+			return
+		}
+		klog.InfoS("Informer caches synced")
+		synced.Store(true)
+	}()
+
+	controller := admission.NewController(o.Config, opts...)
+	if o.ConfigFile != "" {
+		go config.Watch(ctx, o.ConfigFile, o.ConfigReloadInterval, o.Config, func(c admission.Config) {
+			if c.ResolveEnvFrom && !o.Config.ResolveEnvFrom {
+				klog.InfoS("Resolving envFrom takes a restart to take effect")
+			}
+			controller.SetConfig(c)
+		})
+	}
+
+	http.Handle("/webhook", controller)
+	http.Handle("/validate", controller.ValidatingHandler())
+	http.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) {
+		if !synced.Load() {
+			http.Error(w, "informer caches not synced", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
 
 	server := &http.Server{
 		Addr:      o.Address,
 		TLSConfig: o.TLSConfig,
 	}
 
-	return server.ListenAndServeTLS("", "")
+	// Metrics and debug endpoints are served over plain HTTP on their own
+	// ports, so that they can be reached without the webhook's certificate.
+	var metricsServer *http.Server
+	if o.MetricsAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler(registry))
+		metricsServer = &http.Server{
+			Addr:    o.MetricsAddress,
+			Handler: mux,
+		}
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != http.ErrServerClosed {
+				klog.ErrorS(err, "Failed to serve metrics")
+			}
+		}()
+	}
+	var debugServer *http.Server
+	if o.DebugAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/debug/config", config.Handler(controller.EffectiveConfig))
+		if o.RecentDecisions != nil {
+			mux.Handle("/debug/decisions", o.RecentDecisions.Handler())
+		}
+		debugServer = &http.Server{
+			Addr:    o.DebugAddress,
+			Handler: mux,
+		}
+		go func() {
+			if err := debugServer.ListenAndServe(); err != http.ErrServerClosed {
+				klog.ErrorS(err, "Failed to serve debug endpoints")
+			}
+		}()
+	}
+
+	go func() {
+		<-ctx.Done()
+		if err := server.Shutdown(context.Background()); err != nil {
+			klog.ErrorS(err, "Failed to shut down server")
+		}
+		if metricsServer != nil {
+			if err := metricsServer.Shutdown(context.Background()); err != nil {
+				klog.ErrorS(err, "Failed to shut down metrics server")
+			}
+		}
+		if debugServer != nil {
+			if err := debugServer.Shutdown(context.Background()); err != nil {
+				klog.ErrorS(err, "Failed to shut down debug server")
+			}
+		}
+	}()
+
+	if err := server.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
 }
@@ -7,7 +7,7 @@ set -o pipefail
 version=${VERSION:-""}
 registry=${REGISTRY:-"gjkim42"}
 base_image=${BASE_IMAGE:-"gcr.io/distroless/static-debian11"}
-go_version=${GO_VERSION:-"1.20"}
+go_version=${GO_VERSION:-"1.24"}
 os_codename=${OS_CODENAME:-"bullseye"}
 output_dir=${OUTPUT_DIR:-"_output"}
 
@@ -0,0 +1,18 @@
+#!/usr/bin/env bash
+
+# Runs Jaeger locally as a stand-in for an OpenTelemetry collector. Start the
+# gomaxprocs-injector with --tracing-endpoint=localhost:4318 --tracing-insecure
+# and browse the spans at http://localhost:16686.
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+jaeger_image=${JAEGER_IMAGE:-"jaegertracing/all-in-one:1.62.0"}
+
+docker run --rm \
+	--name gomaxprocs-injector-trace-collector \
+	-e COLLECTOR_OTLP_ENABLED=true \
+	-p 4318:4318 \
+	-p 16686:16686 \
+	"${jaeger_image}"
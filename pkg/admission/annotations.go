@@ -0,0 +1,81 @@
+package admission
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// containerValueAnnotationPrefix is followed by a container name and pins
+// GOMAXPROCS for that container.
+var containerValueAnnotationPrefix = "gomaxprocs-injector/value."
+
+// containerInjectAnnotationPrefix returns the prefix that, followed by a
+// container name, enables or disables injection for that container.
+func containerInjectAnnotationPrefix(injectAnnotationKey string) string {
+	return injectAnnotationKey + "."
+}
+
+// isContainerInjectionEnabled evaluates the inject.<container-name>
+// annotation of the pod, named after injectAnnotationKey.
+func isContainerInjectionEnabled(pod *corev1.Pod, injectAnnotationKey, containerName string) (bool, error) {
+	key := containerInjectAnnotationPrefix(injectAnnotationKey) + containerName
+	value, ok := pod.Annotations[key]
+	if !ok {
+		return true, nil
+	}
+
+	switch value {
+	case injectEnabledValue:
+		return true, nil
+	case injectDisabledValue:
+		return false, nil
+	default:
+		return false, annotationError{fmt.Errorf("invalid annotation %s=%q: must be %q or %q", key, value, injectEnabledValue, injectDisabledValue)}
+	}
+}
+
+// pinnedGOMAXPROCS returns the GOMAXPROCS pinned by the value.<container-name>
+// annotation of the pod, or 0 if there is none.
+func pinnedGOMAXPROCS(pod *corev1.Pod, containerName string) (int64, error) {
+	key := containerValueAnnotationPrefix + containerName
+	value, ok := pod.Annotations[key]
+	if !ok {
+		return 0, nil
+	}
+
+	gomaxProcs, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || gomaxProcs < 1 {
+		return 0, annotationError{fmt.Errorf("invalid annotation %s=%q: must be a positive integer", key, value)}
+	}
+	return gomaxProcs, nil
+}
+
+// unknownContainerAnnotations returns warnings for per-container annotations
+// that refer to containers the pod does not have.
+func unknownContainerAnnotations(pod *corev1.Pod, injectAnnotationKey string) []string {
+	names := map[string]bool{}
+	for _, container := range pod.Spec.InitContainers {
+		names[container.Name] = true
+	}
+	for _, container := range pod.Spec.Containers {
+		names[container.Name] = true
+	}
+	for _, container := range pod.Spec.EphemeralContainers {
+		names[container.Name] = true
+	}
+
+	var warnings []string
+	for key := range pod.Annotations {
+		for _, prefix := range []string{containerInjectAnnotationPrefix(injectAnnotationKey), containerValueAnnotationPrefix} {
+			if name := strings.TrimPrefix(key, prefix); name != key && !names[name] {
+				warnings = append(warnings, fmt.Sprintf("annotation %s refers to unknown container %q", key, name))
+			}
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
@@ -0,0 +1,35 @@
+package admission
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestUnknownContainerAnnotations(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				"gomaxprocs-injector/inject":         "enabled",
+				"gomaxprocs-injector/inject.app":     "disabled",
+				"gomaxprocs-injector/inject.missing": "disabled",
+				"gomaxprocs-injector/value.typo":     "2",
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "app"},
+			},
+		},
+	}
+
+	expected := []string{
+		`annotation gomaxprocs-injector/inject.missing refers to unknown container "missing"`,
+		`annotation gomaxprocs-injector/value.typo refers to unknown container "typo"`,
+	}
+	if diff := cmp.Diff(expected, unknownContainerAnnotations(pod, injectAnnotationKey)); diff != "" {
+		t.Errorf("unexpected warnings (-want +got):\n%s", diff)
+	}
+}
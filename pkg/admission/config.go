@@ -0,0 +1,277 @@
+package admission
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gjkim42/gomaxprocs-injector/pkg/gomaxprocs"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Config holds the policy the Controller applies to admitted pods.
+type Config struct {
+	// Version identifies where the Config came from, for example a hash of
+	// the configuration file it was loaded from.
+	Version string
+	// InjectionMode decides whether pods are mutated unless they opt out or
+	// only if they opt in.
+	InjectionMode InjectionMode
+	// InjectAnnotationKey is the annotation, or namespace label, that enables
+	// or disables injection. Followed by a dot and a container name, it does
+	// so for a single container.
+	InjectAnnotationKey string
+	// Mode decides whether the changes are applied or only reported.
+	Mode Mode
+	// GOMAXPROCSEnabled turns GOMAXPROCS injection on.
+	GOMAXPROCSEnabled bool
+	// Strategy computes GOMAXPROCS from the CPU limit of a container.
+	Strategy gomaxprocs.Strategy
+	// Min is the lowest GOMAXPROCS computed from resources. 0 means no bound.
+	Min int64
+	// Max is the highest GOMAXPROCS computed from resources. 0 means no
+	// bound.
+	Max int64
+	// Enforce replaces GOMAXPROCS set by the user if it is not a positive
+	// integer or exceeds the value computed for the container.
+	Enforce bool
+	// RequestFallback configures GOMAXPROCS for containers that have a CPU
+	// request but no CPU limit.
+	RequestFallback RequestFallbackConfig
+	// GOMEMLIMIT configures GOMEMLIMIT injection.
+	GOMEMLIMIT GOMEMLIMITConfig
+	// InitContainers decides whether regular init containers are mutated.
+	// Native sidecars are always mutated like regular containers.
+	InitContainers InitContainerPolicy
+	// ResolveEnvFrom looks up the ConfigMaps and Secrets referenced by
+	// envFrom to detect variables set by the user. It only takes effect if
+	// the Controller has listers for them, see WithEnvFromListers.
+	ResolveEnvFrom bool
+	// EnvFromErrorPolicy decides what happens when the sources referenced by
+	// envFrom cannot be looked up. It only matters when the Controller
+	// resolves envFrom.
+	EnvFromErrorPolicy EnvFromErrorPolicy
+	// DecisionWarnings reports every decision taken for a container as an
+	// admission warning. Decisions are always reported in audit annotations.
+	DecisionWarnings bool
+	// ValidationAction decides what the validating webhook does with pods
+	// whose GOMAXPROCS is misconfigured, unless their namespace says
+	// otherwise.
+	ValidationAction ValidationAction
+	// WorkloadKinds lists the kinds of workloads whose pod templates are
+	// mutated, in addition to pods, among WorkloadKinds(). Pods created from a
+	// mutated template are still mutated, which keeps the values in sync with
+	// the limits the pods end up with.
+	WorkloadKinds []string
+	// CustomResources lists the kinds of custom resources whose embedded pod
+	// specs are mutated.
+	CustomResources []CustomResource
+}
+
+// InjectionMode decides which pods are mutated when neither the pod nor its
+// namespace says otherwise.
+type InjectionMode string
+
+const (
+	// InjectionModeOptOut mutates every pod unless the pod or its namespace
+	// is marked disabled.
+	InjectionModeOptOut InjectionMode = "opt-out"
+	// InjectionModeOptIn mutates only pods that are, or whose namespace is,
+	// marked enabled.
+	InjectionModeOptIn InjectionMode = "opt-in"
+)
+
+// Validate checks that the mode is known.
+func (m InjectionMode) Validate() error {
+	switch m {
+	case InjectionModeOptOut, InjectionModeOptIn:
+		return nil
+	default:
+		return fmt.Errorf("unknown injection mode %q, must be %q or %q", m, InjectionModeOptOut, InjectionModeOptIn)
+	}
+}
+
+// Mode decides whether the Controller applies the changes it computes.
+type Mode string
+
+const (
+	// ModeMutate applies the changes.
+	ModeMutate Mode = "mutate"
+	// ModeReportOnly reports the changes in warnings and audit annotations
+	// without applying them.
+	ModeReportOnly Mode = "report-only"
+)
+
+// Validate checks that the mode is known.
+func (m Mode) Validate() error {
+	switch m {
+	case ModeMutate, ModeReportOnly:
+		return nil
+	default:
+		return fmt.Errorf("unknown mode %q, must be %q or %q", m, ModeMutate, ModeReportOnly)
+	}
+}
+
+// InitContainerPolicy decides whether regular init containers are mutated.
+type InitContainerPolicy string
+
+const (
+	// InitContainerPolicyInject mutates regular init containers.
+	InitContainerPolicyInject InitContainerPolicy = "inject"
+	// InitContainerPolicySkip leaves regular init containers alone.
+	InitContainerPolicySkip InitContainerPolicy = "skip"
+)
+
+// Validate checks that the policy is known.
+func (p InitContainerPolicy) Validate() error {
+	switch p {
+	case InitContainerPolicyInject, InitContainerPolicySkip:
+		return nil
+	default:
+		return fmt.Errorf("unknown init container policy %q, must be %q or %q", p, InitContainerPolicyInject, InitContainerPolicySkip)
+	}
+}
+
+// Validate checks the whole Config.
+func (c Config) Validate() error {
+	if err := c.InjectionMode.Validate(); err != nil {
+		return err
+	}
+	if errs := validation.IsQualifiedName(c.InjectAnnotationKey); len(errs) > 0 {
+		return fmt.Errorf("invalid inject annotation key %q: %s", c.InjectAnnotationKey, strings.Join(errs, "; "))
+	}
+	if err := c.Mode.Validate(); err != nil {
+		return err
+	}
+	if c.Strategy == nil {
+		return fmt.Errorf("strategy must be set")
+	}
+	if err := c.ValidateBounds(); err != nil {
+		return err
+	}
+	if err := c.RequestFallback.Validate(); err != nil {
+		return err
+	}
+	if err := c.GOMEMLIMIT.Validate(); err != nil {
+		return err
+	}
+	if err := c.InitContainers.Validate(); err != nil {
+		return err
+	}
+	if err := c.ValidationAction.Validate(); err != nil {
+		return err
+	}
+	if err := ValidateWorkloadKinds(c.WorkloadKinds); err != nil {
+		return err
+	}
+	for _, cr := range c.CustomResources {
+		if err := cr.Validate(); err != nil {
+			return err
+		}
+	}
+	return c.EnvFromErrorPolicy.Validate()
+}
+
+// ValidateBounds checks that Min and Max form a usable range.
+func (c Config) ValidateBounds() error {
+	if c.Min < 0 || c.Max < 0 {
+		return fmt.Errorf("GOMAXPROCS bounds must not be negative, got min=%d max=%d", c.Min, c.Max)
+	}
+	if c.Max != 0 && c.Min > c.Max {
+		return fmt.Errorf("GOMAXPROCS min %d is greater than max %d", c.Min, c.Max)
+	}
+	return nil
+}
+
+// clamp bounds a computed GOMAXPROCS by Min and Max.
+func (c Config) clamp(gomaxProcs int64) int64 {
+	if c.Min != 0 && gomaxProcs < c.Min {
+		gomaxProcs = c.Min
+	}
+	if c.Max != 0 && gomaxProcs > c.Max {
+		gomaxProcs = c.Max
+	}
+	return gomaxProcs
+}
+
+// RequestFallbackConfig configures how GOMAXPROCS is derived from the CPU
+// request of a container that has no CPU limit.
+type RequestFallbackConfig struct {
+	// Enabled turns the fallback on. A CPU limit always takes priority.
+	Enabled bool
+	// BurstMultiplier scales the CPU request before Strategy is applied.
+	BurstMultiplier float64
+	// Min is the lowest GOMAXPROCS the fallback sets. 0 means no bound.
+	Min int64
+	// Max is the highest GOMAXPROCS the fallback sets. 0 means no bound.
+	Max int64
+}
+
+// Validate checks that the fallback bounds and multiplier are usable.
+func (r RequestFallbackConfig) Validate() error {
+	if r.BurstMultiplier <= 0 {
+		return fmt.Errorf("request fallback burst multiplier must be positive, got %v", r.BurstMultiplier)
+	}
+	if r.Min < 0 || r.Max < 0 {
+		return fmt.Errorf("request fallback bounds must not be negative, got min=%d max=%d", r.Min, r.Max)
+	}
+	if r.Max != 0 && r.Min > r.Max {
+		return fmt.Errorf("request fallback min %d is greater than max %d", r.Min, r.Max)
+	}
+	return nil
+}
+
+// compute returns GOMAXPROCS for a CPU request given in millicores.
+func (r RequestFallbackConfig) compute(strategy gomaxprocs.Strategy, milliCPU int64) int64 {
+	gomaxProcs := strategy.Compute(int64(float64(milliCPU) * r.BurstMultiplier))
+	if r.Min != 0 && gomaxProcs < r.Min {
+		gomaxProcs = r.Min
+	}
+	if r.Max != 0 && gomaxProcs > r.Max {
+		gomaxProcs = r.Max
+	}
+	return gomaxProcs
+}
+
+// GOMEMLIMITConfig configures how GOMEMLIMIT is derived from the memory limit
+// of a container.
+type GOMEMLIMITConfig struct {
+	// Enabled turns GOMEMLIMIT injection on, independently of GOMAXPROCS.
+	Enabled bool
+	// Percent is the percentage of the memory limit GOMEMLIMIT is set to.
+	// For example, 90 leaves 10% of the limit as headroom for memory the Go
+	// runtime does not account for.
+	Percent int64
+}
+
+// Validate checks that Percent is a usable percentage.
+func (g GOMEMLIMITConfig) Validate() error {
+	if g.Percent <= 0 || g.Percent > 100 {
+		return fmt.Errorf("GOMEMLIMIT percent must be between 1 and 100, got %d", g.Percent)
+	}
+	return nil
+}
+
+// compute returns GOMEMLIMIT in bytes for a memory limit given in bytes.
+func (g GOMEMLIMITConfig) compute(limit int64) int64 {
+	return limit * g.Percent / 100
+}
+
+// DefaultConfig returns the Config used when nothing is configured.
+func DefaultConfig() Config {
+	return Config{
+		InjectionMode:       InjectionModeOptOut,
+		InjectAnnotationKey: injectAnnotationKey,
+		Mode:                ModeMutate,
+		GOMAXPROCSEnabled:   true,
+		Strategy:            gomaxprocs.Floor(),
+		RequestFallback: RequestFallbackConfig{
+			BurstMultiplier: 1,
+		},
+		GOMEMLIMIT: GOMEMLIMITConfig{
+			Percent: 90,
+		},
+		InitContainers:     InitContainerPolicyInject,
+		EnvFromErrorPolicy: EnvFromErrorPolicySkip,
+		ValidationAction:   ValidationActionWarn,
+	}
+}
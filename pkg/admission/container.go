@@ -0,0 +1,461 @@
+package admission
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/gjkim42/gomaxprocs-injector/pkg/policy"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+)
+
+// mutation holds the state of mutating a single pod.
+type mutation struct {
+	// pod is the pod being mutated.
+	pod *corev1.Pod
+	// namespace is the namespace of the pod, or nil if it is unknown.
+	namespace *corev1.Namespace
+	// namespaceConfig is the policy for the pod after namespace annotations
+	// have been applied, on top of which policies are applied.
+	namespaceConfig Config
+	// limitRange holds the LimitRange constraints that apply to the pod, or
+	// nil if there are none.
+	limitRange *limitRange
+	// config is the policy for the pod, after namespace and pod annotations
+	// have been applied.
+	config Config
+	// warnings are returned to the client in the admission response.
+	warnings []string
+	// overridden records the user-set values replaced in enforcement mode,
+	// keyed by container name.
+	overridden map[string]overriddenValue
+	// previous holds the variables set by a previous invocation of the
+	// webhook for the same pod.
+	previous injectedValues
+	// injected records the variables set by the webhook.
+	injected injectedValues
+	// appliedPolicies records the policies applied to any container.
+	appliedPolicies map[policy.Ref]bool
+	// policyConflicts records the policies that conflicted on any container.
+	policyConflicts [][]policy.Ref
+	// warned deduplicates warnings.
+	warned map[string]bool
+	// path is the path of the pod spec in a workload or custom resource, if
+	// any.
+	path string
+	// decisions records what was done with each container.
+	decisions []decision
+	// events are the Events to be emitted about the pod.
+	events []event
+}
+
+// overriddenValue is a user-set value replaced in enforcement mode.
+type overriddenValue struct {
+	// Value is the original value set by the user.
+	Value string `json:"value"`
+	// Reason explains why the value was replaced.
+	Reason string `json:"reason"`
+}
+
+func newMutation(pod *corev1.Pod, config Config) *mutation {
+	m := &mutation{
+		pod:             pod,
+		config:          config,
+		namespaceConfig: config,
+		overridden:      map[string]overriddenValue{},
+		appliedPolicies: map[policy.Ref]bool{},
+		warned:          map[string]bool{},
+		previous:        parseInjectedValues(pod),
+		injected:        injectedValues{},
+	}
+
+	// On reinvocation, keep track of what the previous invocation did.
+	if m.previous != nil {
+		if value, ok := pod.Annotations[overriddenAnnotationKey]; ok {
+			if err := json.Unmarshal([]byte(value), &m.overridden); err != nil {
+				klog.ErrorS(err, "Ignoring invalid annotation", "annotation", overriddenAnnotationKey)
+			}
+		}
+		m.carryOverInjected()
+	}
+	return m
+}
+
+// warn adds a warning to the admission response unless it was already added.
+func (m *mutation) warn(warning string) {
+	if m.warned[warning] {
+		return
+	}
+	m.warned[warning] = true
+	m.warnings = append(m.warnings, warning)
+}
+
+// finish records the outcome of the mutation on the pod.
+func (m *mutation) finish() error {
+	if err := m.setAnnotation(injectedAnnotationKey, m.injected, len(m.injected) > 0); err != nil {
+		return err
+	}
+	if err := m.setAnnotation(decisionsAnnotationKey, m.decisions, len(m.decisions) > 0); err != nil {
+		return err
+	}
+	return m.setAnnotation(overriddenAnnotationKey, m.overridden, len(m.overridden) > 0)
+}
+
+// setAnnotation sets the annotation to v encoded as JSON if set is true, and
+// removes it otherwise.
+func (m *mutation) setAnnotation(key string, v interface{}, set bool) error {
+	if !set {
+		delete(m.pod.Annotations, key)
+		return nil
+	}
+
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if m.pod.Annotations == nil {
+		m.pod.Annotations = map[string]string{}
+	}
+	m.pod.Annotations[key] = string(value)
+	return nil
+}
+
+// mutateContainers mutates the init containers and containers of a pod being
+// created.
+func (c *Controller) mutateContainers(m *mutation) error {
+	for i := range m.pod.Spec.InitContainers {
+		container := &m.pod.Spec.InitContainers[i]
+		if !isSidecarContainer(container) && m.config.InitContainers == InitContainerPolicySkip {
+			klog.InfoS("Skipping init container", "container", container.Name)
+			m.decide(decision{Container: container.Name, Action: decisionSkipped, Reason: reasonInitContainerPolicy})
+			continue
+		}
+		if err := c.mutateContainer(m, container); err != nil {
+			return err
+		}
+	}
+	for i := range m.pod.Spec.Containers {
+		if err := c.mutateContainer(m, &m.pod.Spec.Containers[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mutateEphemeralContainers mutates the ephemeral containers that are being
+// added to a running pod. Existing ephemeral containers cannot be changed and
+// are left alone.
+func (c *Controller) mutateEphemeralContainers(m *mutation, oldPod *corev1.Pod) error {
+	existing := make(map[string]bool, len(oldPod.Spec.EphemeralContainers))
+	for _, container := range oldPod.Spec.EphemeralContainers {
+		existing[container.Name] = true
+	}
+
+	for i := range m.pod.Spec.EphemeralContainers {
+		ephemeralContainer := &m.pod.Spec.EphemeralContainers[i]
+		if existing[ephemeralContainer.Name] {
+			continue
+		}
+		if err := c.mutateContainer(m, (*corev1.Container)(&ephemeralContainer.EphemeralContainerCommon)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Controller) mutateContainer(m *mutation, container *corev1.Container) error {
+	enabled, err := isContainerInjectionEnabled(m.pod, m.config.InjectAnnotationKey, container.Name)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		klog.InfoS("Skipping container as injection is disabled", "container", container.Name)
+		m.decide(decision{Container: container.Name, Action: decisionSkipped, Reason: reasonContainerDisabled})
+		return nil
+	}
+
+	config := c.containerConfig(m, container)
+	if config.GOMAXPROCSEnabled {
+		if err := c.mutateGOMAXPROCS(m, config, container); err != nil {
+			return err
+		}
+	}
+	if config.GOMEMLIMIT.Enabled {
+		c.mutateGOMEMLIMIT(m, config, container)
+	}
+
+	return nil
+}
+
+func (c *Controller) mutateGOMAXPROCS(m *mutation, config Config, container *corev1.Container) error {
+	pinned, err := pinnedGOMAXPROCS(m.pod, container.Name)
+	if err != nil {
+		return err
+	}
+
+	d := decision{Container: container.Name, Variable: gomaxprocsEnvName}
+	if cpuLimit := m.effectiveCPULimit(container); cpuLimit > 0 {
+		d.CPULimit = resource.NewMilliQuantity(cpuLimit, resource.DecimalSI).String()
+	}
+
+	if overridden, ok := m.overridden[container.Name]; ok && config.Enforce && m.ownsEnv(container, gomaxprocsEnvName) && m.restoreGOMAXPROCS(config, container, overridden.Value) {
+		c.enforceGOMAXPROCS(m, config, container, d)
+		return nil
+	}
+
+	if !m.ownsEnv(container, gomaxprocsEnvName) && c.isUserSet(m, container, gomaxprocsEnvName) {
+		if config.Enforce {
+			c.enforceGOMAXPROCS(m, config, container, d)
+			return nil
+		}
+		klog.InfoS("Container already has GOMAXPROCS set", "container", container.Name)
+		d.Action, d.Value, d.Reason = decisionKept, envValue(container, gomaxprocsEnvName), reasonUserSet
+		m.decide(d)
+		return nil
+	}
+
+	gomaxProcs, reason := pinned, reasonPinned
+	if gomaxProcs > 0 {
+		klog.InfoS("Setting GOMAXPROCS from annotation", "container", container.Name, "value", gomaxProcs)
+	} else {
+		var ok bool
+		gomaxProcs, reason, ok = m.computeGOMAXPROCS(config, container)
+		if !ok {
+			klog.InfoS("Container has no cpu resource limit", "container", container.Name)
+			m.unsetEnv(container, gomaxprocsEnvName)
+			d.Action, d.Reason = decisionSkipped, reasonNoCPULimit
+			m.decide(d)
+			return nil
+		}
+		klog.InfoS("Setting GOMAXPROCS", "container", container.Name, "value", gomaxProcs, "strategy", config.Strategy.Name())
+		d.Strategy = config.Strategy.Name()
+	}
+
+	d.Action, d.Value, d.Reason = decisionInjected, strconv.FormatInt(gomaxProcs, 10), reason
+	if overridden, ok := m.overridden[container.Name]; ok && m.ownsEnv(container, gomaxprocsEnvName) && envValue(container, gomaxprocsEnvName) == d.Value {
+		// A previous invocation overrode the value set by the user, which
+		// still stands.
+		d.Action, d.Previous, d.Reason = decisionOverridden, overridden.Value, overriddenReason(overridden.Value)
+	}
+	m.setEnv(container, gomaxprocsEnvName, d.Value)
+	m.decide(d)
+	return nil
+}
+
+// computeGOMAXPROCS returns the GOMAXPROCS for the container under config and
+// the resource it was derived from, or false if the container has nothing to
+// derive it from.
+func (m *mutation) computeGOMAXPROCS(config Config, container *corev1.Container) (int64, decisionReason, bool) {
+	if cpuLimit := m.effectiveCPULimit(container); cpuLimit > 0 {
+		return config.clamp(config.Strategy.Compute(cpuLimit)), reasonCPULimit, true
+	}
+	if config.RequestFallback.Enabled && !container.Resources.Requests.Cpu().IsZero() {
+		return config.clamp(config.RequestFallback.compute(config.Strategy, container.Resources.Requests.Cpu().MilliValue())), reasonCPURequest, true
+	}
+	return 0, "", false
+}
+
+// enforceGOMAXPROCS replaces a GOMAXPROCS set by the user in env if it is not
+// a positive integer or exceeds the computed value. Values set through
+// valueFrom or envFrom cannot be inspected and are left alone. d is the
+// decision for the container, to be completed.
+func (c *Controller) enforceGOMAXPROCS(m *mutation, config Config, container *corev1.Container, d decision) {
+	env := findEnv(container, gomaxprocsEnvName)
+	if env == nil || env.ValueFrom != nil {
+		klog.InfoS("Container sets GOMAXPROCS through a reference, not enforcing", "container", container.Name)
+		d.Action, d.Reason = decisionKept, reasonReference
+		m.decide(d)
+		return
+	}
+
+	gomaxProcs, _, ok := m.computeGOMAXPROCS(config, container)
+	if !ok {
+		klog.InfoS("Container has no cpu resource limit, not enforcing GOMAXPROCS", "container", container.Name)
+		d.Action, d.Value, d.Reason = decisionKept, env.Value, reasonNoCPULimit
+		m.decide(d)
+		return
+	}
+
+	var reason string
+	reason, d.Reason = overrideReason(env.Value, gomaxProcs)
+	if reason == "" {
+		klog.InfoS("Container already has GOMAXPROCS set", "container", container.Name)
+		d.Action, d.Value, d.Reason = decisionKept, env.Value, reasonWithinLimit
+		m.decide(d)
+		return
+	}
+
+	klog.InfoS("Overriding GOMAXPROCS", "container", container.Name, "original", env.Value, "value", gomaxProcs, "reason", reason)
+
+	m.overridden[container.Name] = overriddenValue{
+		Value:  env.Value,
+		Reason: reason,
+	}
+	m.warnings = append(m.warnings, fmt.Sprintf("container %q: GOMAXPROCS overridden to %d: %s", container.Name, gomaxProcs, reason))
+	d.Action, d.Previous, d.Strategy = decisionOverridden, env.Value, config.Strategy.Name()
+	env.Value = strconv.FormatInt(gomaxProcs, 10)
+	d.Value = env.Value
+	m.recordInjected(container, gomaxprocsEnvName, env.Value)
+	m.decide(d)
+}
+
+// overrideReason returns why a GOMAXPROCS set by the user to value must be
+// overridden given the computed value, or an empty reason if it can be kept.
+func overrideReason(value string, gomaxProcs int64) (string, decisionReason) {
+	v, err := strconv.ParseInt(value, 10, 64)
+	switch {
+	case err != nil || v < 1:
+		return fmt.Sprintf("%q is not a positive integer", value), reasonNotPositiveInteger
+	case v > gomaxProcs:
+		return fmt.Sprintf("%d exceeds the maximum of %d for the cpu resource limit", v, gomaxProcs), reasonExceedsLimit
+	}
+	return "", ""
+}
+
+// restoreGOMAXPROCS puts back the GOMAXPROCS set by the user to original that
+// a previous invocation overrode, if enforcement no longer overrides it, such
+// as after the cpu resource limit was raised. It reports whether it did, in
+// which case the value is the user's again.
+func (m *mutation) restoreGOMAXPROCS(config Config, container *corev1.Container, original string) bool {
+	if gomaxProcs, _, ok := m.computeGOMAXPROCS(config, container); ok {
+		if reason, _ := overrideReason(original, gomaxProcs); reason != "" {
+			return false
+		}
+	}
+
+	klog.InfoS("Restoring GOMAXPROCS set by the user", "container", container.Name, "value", original)
+	findEnv(container, gomaxprocsEnvName).Value = original
+	m.forgetInjected(container, gomaxprocsEnvName)
+	delete(m.overridden, container.Name)
+	return true
+}
+
+// overriddenReason returns the reason a GOMAXPROCS set by the user to value
+// was overridden in enforcement mode.
+func overriddenReason(value string) decisionReason {
+	if v, err := strconv.ParseInt(value, 10, 64); err != nil || v < 1 {
+		return reasonNotPositiveInteger
+	}
+	return reasonExceedsLimit
+}
+
+func (c *Controller) mutateGOMEMLIMIT(m *mutation, config Config, container *corev1.Container) {
+	if !m.ownsEnv(container, gomemlimitEnvName) && c.isUserSet(m, container, gomemlimitEnvName) {
+		klog.InfoS("Container already has GOMEMLIMIT set", "container", container.Name)
+		m.decide(decision{Container: container.Name, Variable: gomemlimitEnvName, Action: decisionKept, Value: envValue(container, gomemlimitEnvName), Reason: reasonUserSet})
+		return
+	}
+
+	memoryLimit := m.limitRange.containerLimit(container, corev1.ResourceMemory)
+	if memoryLimit.IsZero() {
+		klog.InfoS("Container has no memory resource limit", "container", container.Name)
+		m.unsetEnv(container, gomemlimitEnvName)
+		m.decide(decision{Container: container.Name, Variable: gomemlimitEnvName, Action: decisionSkipped, Reason: reasonNoMemoryLimit})
+		return
+	}
+
+	gomemlimit := config.GOMEMLIMIT.compute(memoryLimit.Value())
+
+	klog.InfoS("Setting GOMEMLIMIT", "container", container.Name, "value", gomemlimit)
+
+	value := strconv.FormatInt(gomemlimit, 10)
+	m.setEnv(container, gomemlimitEnvName, value)
+	m.decide(decision{Container: container.Name, Variable: gomemlimitEnvName, Action: decisionInjected, Value: value, Reason: reasonMemoryLimit})
+}
+
+// effectiveCPULimit returns the CPU limit in millicores that applies to the
+// container, or 0 if there is none. The pod-level limit applies to containers
+// without a limit of their own and caps the containers that have one.
+func (m *mutation) effectiveCPULimit(container *corev1.Container) int64 {
+	limit := m.cpuLimit(container)
+	podLimit := m.podCPULimit()
+	if limit == 0 || (podLimit > 0 && podLimit < limit) {
+		return podLimit
+	}
+	return limit
+}
+
+// podCPULimit returns the CPU limit in millicores of the pod as a whole, or 0
+// if the pod is unbounded. Without spec.resources, the pod is bounded only if
+// every container has a limit, in which case the kubelet sizes the pod cgroup
+// for the containers and sidecars running together or the largest regular
+// init container, whichever is bigger. A LimitRange maximum for pods caps
+// either.
+func (m *mutation) podCPULimit() int64 {
+	limit := m.specifiedPodCPULimit()
+	if podMax := m.limitRange.podLimit(corev1.ResourceCPU); limit > 0 && !podMax.IsZero() && podMax.MilliValue() < limit {
+		return podMax.MilliValue()
+	}
+	return limit
+}
+
+func (m *mutation) specifiedPodCPULimit() int64 {
+	pod := m.pod
+	if pod.Spec.Resources != nil && !pod.Spec.Resources.Limits.Cpu().IsZero() {
+		return pod.Spec.Resources.Limits.Cpu().MilliValue()
+	}
+
+	var sum, initMax int64
+	for i := range pod.Spec.Containers {
+		limit := m.cpuLimit(&pod.Spec.Containers[i])
+		if limit == 0 {
+			return 0
+		}
+		sum += limit
+	}
+	for i := range pod.Spec.InitContainers {
+		container := &pod.Spec.InitContainers[i]
+		limit := m.cpuLimit(container)
+		if limit == 0 {
+			return 0
+		}
+		if isSidecarContainer(container) {
+			sum += limit
+		} else if limit > initMax {
+			initMax = limit
+		}
+	}
+
+	if initMax > sum {
+		return initMax
+	}
+	return sum
+}
+
+// cpuLimit returns the CPU limit in millicores of the container, taking
+// LimitRanges into account, or 0 if there is none.
+func (m *mutation) cpuLimit(container *corev1.Container) int64 {
+	limit := m.limitRange.containerLimit(container, corev1.ResourceCPU)
+	return limit.MilliValue()
+}
+
+// isSidecarContainer reports whether an init container is a native sidecar,
+// which keeps running alongside the containers of the pod.
+func isSidecarContainer(container *corev1.Container) bool {
+	return container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways
+}
+
+func hasEnv(container *corev1.Container, name string) bool {
+	return findEnv(container, name) != nil
+}
+
+// envValue returns the value of the variable of the container set in env, or
+// an empty string if it is not set there or is set through valueFrom.
+func envValue(container *corev1.Container, name string) string {
+	if env := findEnv(container, name); env != nil {
+		return env.Value
+	}
+	return ""
+}
+
+// findEnv returns the env entry of the container with the given name, or nil.
+func findEnv(container *corev1.Container, name string) *corev1.EnvVar {
+	for i := range container.Env {
+		if container.Env[i].Name == name {
+			return &container.Env[i]
+		}
+	}
+	return nil
+}
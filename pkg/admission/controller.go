@@ -1,36 +1,209 @@
 package admission
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"math"
 	"net/http"
-	"strconv"
+	"sync/atomic"
+	"time"
 
+	"github.com/gjkim42/gomaxprocs-injector/pkg/decisionlog"
+	"github.com/gjkim42/gomaxprocs-injector/pkg/metrics"
+	"github.com/gjkim42/gomaxprocs-injector/pkg/policy"
 	"github.com/wI2L/jsondiff"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 	v1 "k8s.io/api/admission/v1"
 	"k8s.io/api/admission/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 )
 
 var (
+	podResource = metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"}
+
 	patchTypeJSONPatch  = v1.PatchTypeJSONPatch
 	injectAnnotationKey = "gomaxprocs-injector/inject"
+	injectEnabledValue  = "enabled"
 	injectDisabledValue = "disabled"
+
+	overriddenAnnotationKey = "gomaxprocs-injector/overridden"
+)
+
+const (
+	ephemeralContainersSubResource = "ephemeralcontainers"
+
+	mutatingWebhook   = "mutating"
+	validatingWebhook = "validating"
+
+	gomaxprocsEnvName = "GOMAXPROCS"
+	gomemlimitEnvName = "GOMEMLIMIT"
 )
 
 type Controller struct {
+	config           atomic.Pointer[Config]
+	envFrom          *envFromResolver
+	namespaceLister  corelisters.NamespaceLister
+	limitRangeLister corelisters.LimitRangeLister
+	policies         policy.Lister
+	policyTracker    *policy.Tracker
+	metrics          *metrics.Metrics
+	tracer           trace.Tracer
+	decisionLog      *decisionlog.Logger
+	recentDecisions  *decisionlog.Ring
+	recorder         record.EventRecorder
+}
+
+// Option configures optional dependencies of a Controller.
+type Option func(*Controller)
+
+// WithEnvFromListers makes the Controller resolve the ConfigMaps and Secrets
+// referenced by envFrom when deciding whether a variable is already set, if
+// Config.ResolveEnvFrom is set.
+func WithEnvFromListers(configMapLister corelisters.ConfigMapLister, secretLister corelisters.SecretLister) Option {
+	return func(c *Controller) {
+		c.envFrom = &envFromResolver{
+			configMapLister: configMapLister,
+			secretLister:    secretLister,
+		}
+	}
+}
+
+// WithNamespaceLister makes the Controller take the labels and annotations of
+// the namespace of a pod into account.
+func WithNamespaceLister(namespaceLister corelisters.NamespaceLister) Option {
+	return func(c *Controller) {
+		c.namespaceLister = namespaceLister
+	}
+}
+
+// WithLimitRangeLister makes the Controller compute GOMAXPROCS and GOMEMLIMIT
+// from the limits containers get from the LimitRanges of their namespace.
+func WithLimitRangeLister(limitRangeLister corelisters.LimitRangeLister) Option {
+	return func(c *Controller) {
+		c.limitRangeLister = limitRangeLister
+	}
+}
+
+// WithPolicies makes the Controller apply the GOMAXPROCSPolicies and
+// ClusterGOMAXPROCSPolicies listed by lister. If tracker is not nil, the
+// policies applied to each pod are recorded for their status.
+func WithPolicies(lister policy.Lister, tracker *policy.Tracker) Option {
+	return func(c *Controller) {
+		c.policies = lister
+		c.policyTracker = tracker
+	}
+}
+
+// WithMetrics makes the Controller record its admission traffic and decisions
+// in m.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(c *Controller) {
+		c.metrics = m
+	}
+}
+
+// WithTracerProvider makes the Controller trace admission requests with the
+// tracers of tp.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *Controller) {
+		c.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// WithDecisionLog makes the Controller write every admission request and the
+// decisions taken for it to l, as sampled by l.
+func WithDecisionLog(l *decisionlog.Logger) Option {
+	return func(c *Controller) {
+		c.decisionLog = l
+	}
+}
+
+// WithEventRecorder makes the Controller emit Events about the pods it
+// overrides GOMAXPROCS for or cannot mutate as asked, with recorder.
+func WithEventRecorder(recorder record.EventRecorder) Option {
+	return func(c *Controller) {
+		c.recorder = recorder
+	}
+}
+
+// WithRecentDecisions makes the Controller keep every admission request and
+// the decisions taken for it in r, unsampled.
+func WithRecentDecisions(r *decisionlog.Ring) Option {
+	return func(c *Controller) {
+		c.recentDecisions = r
+	}
 }
 
-func NewController() *Controller {
-	return &Controller{}
+func NewController(config Config, opts ...Option) *Controller {
+	c := &Controller{
+		tracer: noop.NewTracerProvider().Tracer(tracerName),
+	}
+	c.SetConfig(config)
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// SetConfig replaces the policy of the Controller. Requests being admitted
+// keep using the policy they started with.
+func (c *Controller) SetConfig(config Config) {
+	c.config.Store(&config)
+}
+
+// Config returns the current policy of the Controller.
+func (c *Controller) Config() Config {
+	return *c.config.Load()
+}
+
+// EffectiveConfig returns the policy for pods in the named namespace, after
+// the annotations of the namespace have been applied, and the warnings about
+// invalid annotations. Without a namespace, a namespace cache, or if the
+// namespace cannot be found, it is the policy of the Controller.
+func (c *Controller) EffectiveConfig(namespace string) (Config, []string) {
+	if namespace == "" {
+		return c.Config(), nil
+	}
+	return namespaceConfig(c.Config(), c.namespace(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace}}))
 }
 
 func (c *Controller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.serve(w, r, mutatingWebhook, c.admit)
+}
+
+// ValidatingHandler returns the handler of the validating webhook, which
+// checks pods after all mutating webhooks have run.
+func (c *Controller) ValidatingHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.serve(w, r, validatingWebhook, c.validate)
+	})
+}
+
+// serve decodes the AdmissionReview in the request, passes it to admit and
+// writes the response in the same version. The trace context of the API
+// server, if any, is the parent of the spans of the request.
+func (c *Controller) serve(w http.ResponseWriter, r *http.Request, webhook string, admit func(context.Context, v1.AdmissionReview) *v1.AdmissionResponse) {
+	ctx := propagation.TraceContext{}.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := c.tracer.Start(ctx, spanServeHTTP, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attributeWebhook.String(webhook)))
+	defer span.End()
+
+	apiVersion, result, namespace := "unknown", metrics.ResultError, ""
+	defer func() {
+		c.metrics.ObserveRequest(webhook, apiVersion, result, namespace)
+		if result == metrics.ResultError {
+			span.SetStatus(codes.Error, "failed to handle the admission request")
+		}
+	}()
+
 	var body []byte
 	if r.Body != nil {
 		if data, err := ioutil.ReadAll(r.Body); err == nil {
@@ -47,8 +220,12 @@ func (c *Controller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	klog.V(2).Info("Handling", "request", string(body))
 
+	start := time.Now()
+	_, decodeSpan := c.tracer.Start(ctx, spanDecode)
 	deserializer := codecs.UniversalDeserializer()
 	obj, gvk, err := deserializer.Decode(body, nil, nil)
+	endSpan(decodeSpan, err)
+	c.metrics.ObserveStage(webhook, metrics.StageDecode, start)
 	if err != nil {
 		msg := fmt.Sprintf("Failed to deserialize request object: %v", err)
 		klog.ErrorS(err, msg)
@@ -56,7 +233,10 @@ func (c *Controller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var request *v1.AdmissionRequest
 	var responseObj runtime.Object
+	var response *v1.AdmissionResponse
+	start = time.Now()
 	switch *gvk {
 	case v1beta1.SchemeGroupVersion.WithKind("AdmissionReview"):
 		requestedAdmissionReview, ok := obj.(*v1beta1.AdmissionReview)
@@ -64,31 +244,50 @@ func (c *Controller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			klog.ErrorS(nil, "Wrong AdmissionReview type", "expect", "v1beta1.AdmissionReview", "got", fmt.Sprintf("%T", obj))
 			return
 		}
+		request = convertAdmissionRequestToV1(requestedAdmissionReview.Request)
 		responseAdmissionReview := &v1beta1.AdmissionReview{}
 		responseAdmissionReview.SetGroupVersionKind(*gvk)
-		responseAdmissionReview.Response = c.admitV1beta1(*requestedAdmissionReview)
+		responseAdmissionReview.Response = convertAdmissionResponseToV1beta1(c.traceAdmit(ctx, span, admit, request))
 		responseAdmissionReview.Response.UID = requestedAdmissionReview.Request.UID
 		responseObj = responseAdmissionReview
+		response = convertAdmissionResponseToV1(responseAdmissionReview.Response)
 	case v1.SchemeGroupVersion.WithKind("AdmissionReview"):
 		requestedAdmissionReview, ok := obj.(*v1.AdmissionReview)
 		if !ok {
 			klog.ErrorS(nil, "Wrong AdmissionReview type", "expect", "v1.AdmissionReview", "got", fmt.Sprintf("%T", obj))
 			return
 		}
+		request = requestedAdmissionReview.Request
 		responseAdmissionReview := &v1.AdmissionReview{}
 		responseAdmissionReview.SetGroupVersionKind(*gvk)
-		responseAdmissionReview.Response = c.admit(*requestedAdmissionReview)
+		responseAdmissionReview.Response = c.traceAdmit(ctx, span, admit, request)
 		responseAdmissionReview.Response.UID = requestedAdmissionReview.Request.UID
 		responseObj = responseAdmissionReview
+		response = responseAdmissionReview.Response
 	default:
 		msg := fmt.Sprintf("Unsupported group version kind: %v", gvk)
 		klog.ErrorS(nil, msg)
 		http.Error(w, msg, http.StatusBadRequest)
 		return
 	}
+	c.metrics.ObserveStage(webhook, metrics.StageCompute, start)
+	apiVersion = gvk.Version
+	namespace = request.Namespace
+	span.SetAttributes(attributeAPIVersion.String(apiVersion))
+	if sampled := c.decisionLog.Sampled(); sampled || c.recentDecisions != nil {
+		entry := decisionLogEntry(webhook, request, response)
+		if sampled {
+			c.decisionLog.Log(entry)
+		}
+		c.recentDecisions.Add(entry)
+	}
 
 	klog.V(2).Info("Sending", "response", responseObj)
+	start = time.Now()
+	_, marshalSpan := c.tracer.Start(ctx, spanMarshal)
 	respBytes, err := json.Marshal(responseObj)
+	endSpan(marshalSpan, err)
+	c.metrics.ObserveStage(webhook, metrics.StageEncode, start)
 	if err != nil {
 		msg := fmt.Sprintf("Failed to serialize response object: %v", err)
 		klog.ErrorS(err, msg)
@@ -98,13 +297,60 @@ func (c *Controller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if _, err := w.Write(respBytes); err != nil {
 		klog.ErrorS(err, "Failed to write response")
+		return
+	}
+
+	switch {
+	case !response.Allowed:
+		result = metrics.ResultDenied
+	case response.Patch != nil:
+		result = metrics.ResultPatched
+	default:
+		result = metrics.ResultAllowed
+	}
+}
+
+// traceAdmit passes request to admit in a span of its own, and describes the
+// request and the response in the span of the whole request.
+func (c *Controller) traceAdmit(ctx context.Context, span trace.Span, admit func(context.Context, v1.AdmissionReview) *v1.AdmissionResponse, request *v1.AdmissionRequest) *v1.AdmissionResponse {
+	span.SetAttributes(requestAttributes(request)...)
+
+	ctx, admitSpan := c.tracer.Start(ctx, spanAdmit)
+	response := admit(ctx, v1.AdmissionReview{Request: request})
+	if !response.Allowed && response.Result != nil {
+		admitSpan.AddEvent("denied", trace.WithAttributes(attributeMessage.String(response.Result.Message)))
+	}
+	admitSpan.End()
+
+	span.SetAttributes(attributeAllowed.Bool(response.Allowed))
+	return response
+}
+
+func (c *Controller) admit(ctx context.Context, review v1.AdmissionReview) *v1.AdmissionResponse {
+	if review.Request.Resource == podResource {
+		return c.admitPod(ctx, review)
 	}
+	config := c.Config()
+	if kind, ok := config.workloadKind(review.Request.Resource); ok {
+		return c.admitWorkload(ctx, review, kind)
+	}
+	if cr, ok := config.customResource(review.Request.Kind); ok {
+		return c.admitCustomResource(ctx, review, cr)
+	}
+
+	err := fmt.Errorf("unsupported resource %s of kind %s: expected %s, an enabled workload kind or a configured custom resource", review.Request.Resource, review.Request.Kind, podResource)
+	klog.ErrorS(err, "Failed to admit")
+	return toV1AdmissionResponse(err)
 }
 
-func (c *Controller) admit(review v1.AdmissionReview) *v1.AdmissionResponse {
-	podResource := metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"}
-	if review.Request.Resource != podResource {
-		err := fmt.Errorf("expected resource to be %s", podResource)
+func (c *Controller) admitPod(ctx context.Context, review v1.AdmissionReview) *v1.AdmissionResponse {
+	ephemeral := false
+	switch {
+	case review.Request.SubResource == "":
+	case review.Request.SubResource == ephemeralContainersSubResource && review.Request.Operation == v1.Update:
+		ephemeral = true
+	default:
+		err := fmt.Errorf("unsupported operation %s on subresource %q of %s", review.Request.Operation, review.Request.SubResource, podResource)
 		klog.ErrorS(err, "Failed to admit")
 		return toV1AdmissionResponse(err)
 	}
@@ -115,101 +361,240 @@ func (c *Controller) admit(review v1.AdmissionReview) *v1.AdmissionResponse {
 		return toV1AdmissionResponse(err)
 	}
 
+	// The namespace is not set on pods being created. Fill it in on both the
+	// original and the mutated pod so that it does not end up in the patch.
+	if pod.Namespace == "" {
+		pod.Namespace = review.Request.Namespace
+	}
+
 	klog.InfoS("Admitting a pod", "pod", klog.KObj(&pod))
+	trace.SpanFromContext(ctx).SetAttributes(podAttributes(&pod)...)
 
-	if !isInjectionEnabled(&pod) {
+	// Events are not emitted for dry runs, which have no side effects.
+	dryRun := review.Request.DryRun != nil && *review.Request.DryRun
+	newPod := pod.DeepCopy()
+	m, err := c.newPodMutation(newPod)
+	if err != nil {
+		klog.ErrorS(err, "Failed to admit")
+		if !dryRun {
+			c.emitEvents(&pod, c.namespace(&pod), invalidAnnotationEvents(err))
+		}
+		return toV1AdmissionResponse(err)
+	}
+	if m == nil {
 		klog.InfoS("Skipping pod as injection is disabled", "pod", klog.KObj(&pod))
 		return &v1.AdmissionResponse{
 			Allowed: true,
 		}
 	}
 
-	newPod := pod.DeepCopy()
-	for i := range newPod.Spec.InitContainers {
-		if err := mutateContainer(&newPod.Spec.InitContainers[i]); err != nil {
-			klog.ErrorS(err, "Failed to mutate container")
+	if ephemeral {
+		var oldPod corev1.Pod
+		if err := json.Unmarshal(review.Request.OldObject.Raw, &oldPod); err != nil {
+			klog.ErrorS(err, "Failed to unmarshal old pod")
 			return toV1AdmissionResponse(err)
 		}
-	}
-	for i := range newPod.Spec.Containers {
-		if err := mutateContainer(&newPod.Spec.Containers[i]); err != nil {
+		if err := c.mutateEphemeralContainers(m, &oldPod); err != nil {
 			klog.ErrorS(err, "Failed to mutate container")
+			if !dryRun {
+				c.emitEvents(&pod, m.namespace, invalidAnnotationEvents(err))
+			}
+			return toV1AdmissionResponse(err)
+		}
+	} else {
+		// The ephemeralcontainers subresource ignores changes to anything but
+		// ephemeral containers, so the pod is only annotated on creation.
+		if err := c.mutatePodSpec(m); err != nil {
+			klog.ErrorS(err, "Failed to mutate pod")
+			if !dryRun {
+				c.emitEvents(&pod, m.namespace, invalidAnnotationEvents(err))
+			}
 			return toV1AdmissionResponse(err)
 		}
 	}
+	if !dryRun {
+		c.emitEvents(&pod, m.namespace, m.events)
+	}
+	// Policies are not applied in report-only mode. Each pod is recorded once,
+	// as it is first admitted, rather than again on reinvocation or when
+	// ephemeral containers are added.
+	if !dryRun && m.config.Mode != ModeReportOnly && !ephemeral && review.Request.Operation == v1.Create && !admittedBefore(&pod) {
+		c.recordPolicies(m)
+	}
+
+	return c.patchResponse(ctx, &pod, newPod, m.outcome(), "pod", klog.KObj(&pod))
+}
+
+// newPodMutation prepares the mutation of pod, or returns nil if injection is
+// disabled for the pod.
+func (c *Controller) newPodMutation(pod *corev1.Pod) (*mutation, error) {
+	baseConfig := c.Config()
+	namespace := c.namespace(pod)
+	if !isInjectionEnabled(baseConfig, pod, namespace) {
+		return nil, nil
+	}
+
+	nsConfig, warnings := namespaceConfig(baseConfig, namespace)
+	config, err := podConfig(nsConfig, pod)
+	if err != nil {
+		return nil, err
+	}
+
+	m := newMutation(pod, config)
+	m.namespace = namespace
+	m.namespaceConfig = nsConfig
+	m.warnings = append(m.warnings, warnings...)
+	for _, warning := range warnings {
+		m.events = append(m.events, event{onNamespace: true, eventType: corev1.EventTypeWarning, reason: eventReasonInvalidAnnotation, message: warning})
+	}
+	m.warnings = append(m.warnings, unknownContainerAnnotations(pod, config.InjectAnnotationKey)...)
+	return m, nil
+}
+
+// mutatePodSpec mutates the init containers and containers of a pod that is
+// being created, or will be created from a pod template, and annotates it.
+func (c *Controller) mutatePodSpec(m *mutation) error {
+	// LimitRanger only sets the limits of pods being created. Ephemeral
+	// containers cannot have resources.
+	m.limitRange = c.limitRange(m.pod.Namespace)
+	if err := c.mutateContainers(m); err != nil {
+		return err
+	}
+	return m.finish()
+}
+
+// outcome is what admitting an object produced, besides the changes to the
+// object.
+type outcome struct {
+	warnings  []string
+	decisions []decision
+	// reportOnly reports the changes instead of applying them.
+	reportOnly bool
+}
+
+// outcome returns the outcome of the mutation.
+func (m *mutation) outcome() outcome {
+	return outcome{
+		warnings:   m.warnings,
+		decisions:  m.decisions,
+		reportOnly: m.config.Mode == ModeReportOnly,
+	}
+}
 
-	patch, err := jsondiff.Compare(pod, newPod)
+// patchResponse returns a response that allows the object with the JSONPatch
+// that turns original into mutated and reports the decisions taken. In
+// report-only mode, the JSONPatch is reported in an audit annotation instead.
+func (c *Controller) patchResponse(ctx context.Context, original, mutated interface{}, o outcome, kind string, ref klog.ObjectRef) *v1.AdmissionResponse {
+	mode := ModeMutate
+	if o.reportOnly {
+		mode = ModeReportOnly
+	}
+	for _, d := range o.decisions {
+		c.metrics.ObserveDecision(d.Variable, string(d.Action), string(d.Reason), string(mode))
+	}
+
+	start := time.Now()
+	_, span := c.tracer.Start(ctx, spanCompare)
+	patch, err := jsondiff.Compare(original, mutated)
+	if err == nil {
+		span.SetAttributes(attributePatchOperations.Int(len(patch)))
+	}
+	endSpan(span, err)
+	c.metrics.ObserveStage(mutatingWebhook, metrics.StageDiff, start)
 	if err != nil {
 		klog.ErrorS(err, "Failed to create JSONPatch")
 		return toV1AdmissionResponse(err)
 	}
 
 	if len(patch) == 0 {
-		klog.InfoS("No changes to pod", "pod", klog.KObj(&pod))
+		klog.InfoS("No changes to "+kind, kind, ref)
 		return &v1.AdmissionResponse{
-			Allowed: true,
+			Allowed:          true,
+			Warnings:         o.warnings,
+			AuditAnnotations: auditAnnotations(o.decisions),
 		}
 	}
 
-	klog.InfoS("Patching pod", "pod", klog.KObj(&pod), "patch", patch)
-
 	patchBytes, err := json.Marshal(patch)
 	if err != nil {
 		klog.ErrorS(err, "Failed to marshal JSONPatch")
 		return toV1AdmissionResponse(err)
 	}
+	c.metrics.ObservePatchSize(len(patchBytes))
 
-	return &v1.AdmissionResponse{
-		Allowed:   true,
-		Patch:     patchBytes,
-		PatchType: &patchTypeJSONPatch,
-	}
-}
-
-func mutateContainer(container *corev1.Container) error {
-	for _, env := range container.Env {
-		if env.Name == "GOMAXPROCS" {
-			klog.InfoS("Container already has GOMAXPROCS set", "container", container.Name)
-			return nil
+	if o.reportOnly {
+		klog.InfoS("Not patching "+kind+" in report-only mode", kind, ref, "patch", patch)
+		annotations := auditAnnotations(o.decisions)
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[patchAuditAnnotationKey] = string(patchBytes)
+		warnings := append([]string{fmt.Sprintf("report-only mode: %d changes to the %s were not applied", len(patch), kind)}, o.warnings...)
+		return &v1.AdmissionResponse{
+			Allowed:          true,
+			Warnings:         warnings,
+			AuditAnnotations: annotations,
 		}
 	}
 
-	if container.Resources.Limits == nil || container.Resources.Limits.Cpu().IsZero() {
-		klog.InfoS("Container has no cpu resource limit", "container", container.Name)
-		return nil
-	}
+	klog.InfoS("Patching "+kind, kind, ref, "patch", patch)
 
-	quota := float64(container.Resources.Limits.Cpu().MilliValue()) / 1000.0
-	gomaxProcs := int64(math.Floor(quota))
-	if gomaxProcs < 1 {
-		gomaxProcs = 1
+	return &v1.AdmissionResponse{
+		Allowed:          true,
+		Patch:            patchBytes,
+		PatchType:        &patchTypeJSONPatch,
+		Warnings:         o.warnings,
+		AuditAnnotations: auditAnnotations(o.decisions),
 	}
+}
 
-	klog.InfoS("Setting GOMAXPROCS", "container", container.Name, "value", gomaxProcs)
-
-	container.Env = append(container.Env, corev1.EnvVar{
-		Name:  "GOMAXPROCS",
-		Value: strconv.FormatInt(gomaxProcs, 10),
-	})
-
-	return nil
+func (c *Controller) admitV1beta1(ctx context.Context, review v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
+	return reviewV1beta1(ctx, review, c.admit)
 }
 
-func (c *Controller) admitV1beta1(review v1beta1.AdmissionReview) *v1beta1.AdmissionResponse {
+// reviewV1beta1 passes a v1beta1 AdmissionReview to admit, which handles v1.
+func reviewV1beta1(ctx context.Context, review v1beta1.AdmissionReview, admit func(context.Context, v1.AdmissionReview) *v1.AdmissionResponse) *v1beta1.AdmissionResponse {
 	in := v1.AdmissionReview{Request: convertAdmissionRequestToV1(review.Request)}
-	out := c.admit(in)
+	out := admit(ctx, in)
 	return convertAdmissionResponseToV1beta1(out)
 }
 
-func isInjectionEnabled(pod *corev1.Pod) bool {
-	if pod.Annotations == nil {
-		return true
+// namespace returns the namespace of the pod from the cache, or nil if the
+// Controller has no namespace cache or the namespace cannot be found.
+func (c *Controller) namespace(pod *corev1.Pod) *corev1.Namespace {
+	if c.namespaceLister == nil {
+		return nil
+	}
+
+	namespace, err := c.namespaceLister.Get(pod.Namespace)
+	if err != nil {
+		klog.ErrorS(err, "Failed to get namespace", "namespace", pod.Namespace)
+		return nil
+	}
+	return namespace
+}
+
+// isInjectionEnabled decides whether the pod is mutated. The inject annotation
+// of the pod takes precedence over the inject setting of its namespace. If
+// neither says anything, pods are mutated in opt-out mode and left alone in
+// opt-in mode.
+func isInjectionEnabled(config Config, pod *corev1.Pod, namespace *corev1.Namespace) bool {
+	mode := config.InjectionMode
+	if value, ok := pod.Annotations[config.InjectAnnotationKey]; ok {
+		if mode == InjectionModeOptIn {
+			return value == injectEnabledValue
+		}
+		return value != injectDisabledValue
 	}
 
-	enabled, ok := pod.Annotations[injectAnnotationKey]
-	if !ok {
-		return true
+	if namespace != nil {
+		switch namespaceInjectValue(namespace, config.InjectAnnotationKey) {
+		case injectEnabledValue:
+			return true
+		case injectDisabledValue:
+			return false
+		}
 	}
 
-	return enabled != injectDisabledValue
+	return mode != InjectionModeOptIn
 }
@@ -1,28 +1,42 @@
 package admission
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/gjkim42/gomaxprocs-injector/pkg/gomaxprocs"
+	"github.com/gjkim42/gomaxprocs-injector/pkg/metrics"
 	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/wI2L/jsondiff"
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
 	v1 "k8s.io/api/admission/v1"
 	"k8s.io/api/admission/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 )
 
 func TestAdmit(t *testing.T) {
 	testCases := []struct {
 		desc   string
+		config *Config
 		review v1.AdmissionReview
 
 		allowed                          bool
 		expectedInitContainersGOMAXPROCS []int
 		expectedContainersGOMAXPROCS     []int
+		expectedDecisions                string
 	}{
 		{
 			desc: "should not accept a resource other than pods",
@@ -85,6 +99,14 @@ func TestAdmit(t *testing.T) {
 				2,
 				3, // container-with-GOMAXPROCS
 			},
+			expectedDecisions: `[{"container":"container-without-cpu-limit","variable":"GOMAXPROCS","action":"skipped","reason":"no-cpu-limit"},` +
+				`{"container":"","variable":"GOMAXPROCS","action":"injected","value":"1","cpuLimit":"100m","strategy":"floor","reason":"cpu-limit"},` +
+				`{"container":"","variable":"GOMAXPROCS","action":"injected","value":"1","cpuLimit":"1","strategy":"floor","reason":"cpu-limit"},` +
+				`{"container":"","variable":"GOMAXPROCS","action":"injected","value":"1","cpuLimit":"1100m","strategy":"floor","reason":"cpu-limit"},` +
+				`{"container":"","variable":"GOMAXPROCS","action":"injected","value":"1","cpuLimit":"1900m","strategy":"floor","reason":"cpu-limit"},` +
+				`{"container":"","variable":"GOMAXPROCS","action":"injected","value":"2","cpuLimit":"2","strategy":"floor","reason":"cpu-limit"},` +
+				`{"container":"","variable":"GOMAXPROCS","action":"injected","value":"2","cpuLimit":"2500m","strategy":"floor","reason":"cpu-limit"},` +
+				`{"container":"container-with-GOMAXPROCS","variable":"GOMAXPROCS","action":"kept","value":"3","cpuLimit":"8","reason":"user-set"}]`,
 		},
 		{
 			desc: "test pod with init containers",
@@ -118,6 +140,8 @@ func TestAdmit(t *testing.T) {
 			expectedContainersGOMAXPROCS: []int{
 				3,
 			},
+			expectedDecisions: `[{"container":"","variable":"GOMAXPROCS","action":"injected","value":"1","cpuLimit":"100m","strategy":"floor","reason":"cpu-limit"},` +
+				`{"container":"","variable":"GOMAXPROCS","action":"injected","value":"3","cpuLimit":"3","strategy":"floor","reason":"cpu-limit"}]`,
 		},
 		{
 			desc: "if pod does not need patch",
@@ -146,6 +170,353 @@ func TestAdmit(t *testing.T) {
 				0,
 				0,
 			},
+			expectedDecisions: `[{"container":"","variable":"GOMAXPROCS","action":"skipped","reason":"no-cpu-limit"},` +
+				`{"container":"","variable":"GOMAXPROCS","action":"skipped","reason":"no-cpu-limit"}]`,
+		},
+		{
+			desc: "test pod with ceil strategy",
+			config: &Config{
+				GOMAXPROCSEnabled: true,
+				Strategy:          gomaxprocs.Ceil(),
+			},
+			review: v1.AdmissionReview{
+				Request: &v1.AdmissionRequest{
+					Resource: metav1.GroupVersionResource{
+						Group:    "",
+						Version:  "v1",
+						Resource: "pods",
+					},
+					Object: newTestPodObject(t,
+						"test-pod",
+						containerWithCPULimit("100m"),
+						containerWithCPULimit("1"),
+						containerWithCPULimit("1100m"),
+						containerWithCPULimit("1900m"),
+					),
+				},
+			},
+			allowed: true,
+			expectedContainersGOMAXPROCS: []int{
+				1,
+				1,
+				2,
+				2,
+			},
+			expectedDecisions: `[{"container":"","variable":"GOMAXPROCS","action":"injected","value":"1","cpuLimit":"100m","strategy":"ceil","reason":"cpu-limit"},` +
+				`{"container":"","variable":"GOMAXPROCS","action":"injected","value":"1","cpuLimit":"1","strategy":"ceil","reason":"cpu-limit"},` +
+				`{"container":"","variable":"GOMAXPROCS","action":"injected","value":"2","cpuLimit":"1100m","strategy":"ceil","reason":"cpu-limit"},` +
+				`{"container":"","variable":"GOMAXPROCS","action":"injected","value":"2","cpuLimit":"1900m","strategy":"ceil","reason":"cpu-limit"}]`,
+		},
+		{
+			desc: "test pod with request fallback",
+			config: &Config{
+				GOMAXPROCSEnabled: true,
+				Strategy:          gomaxprocs.Floor(),
+				RequestFallback: RequestFallbackConfig{
+					Enabled:         true,
+					BurstMultiplier: 2,
+					Min:             2,
+					Max:             6,
+				},
+			},
+			review: v1.AdmissionReview{
+				Request: &v1.AdmissionRequest{
+					Resource: metav1.GroupVersionResource{
+						Group:    "",
+						Version:  "v1",
+						Resource: "pods",
+					},
+					Object: newTestPodObject(t,
+						"test-pod",
+						corev1.Container{
+							Name: "container-without-cpu-resources",
+						},
+						containerWithCPURequest("100m"),
+						containerWithCPURequest("1500m"),
+						containerWithCPURequest("8"),
+						corev1.Container{
+							Name: "container-with-cpu-request-and-limit",
+							Resources: corev1.ResourceRequirements{
+								Requests: corev1.ResourceList{
+									corev1.ResourceCPU: resource.MustParse("1"),
+								},
+								Limits: corev1.ResourceList{
+									corev1.ResourceCPU: resource.MustParse("8"),
+								},
+							},
+						},
+					),
+				},
+			},
+			allowed: true,
+			expectedContainersGOMAXPROCS: []int{
+				0, // container-without-cpu-resources
+				2,
+				3,
+				6,
+				8, // container-with-cpu-request-and-limit
+			},
+			expectedDecisions: `[{"container":"container-without-cpu-resources","variable":"GOMAXPROCS","action":"skipped","reason":"no-cpu-limit"},` +
+				`{"container":"","variable":"GOMAXPROCS","action":"injected","value":"2","strategy":"floor","reason":"cpu-request"},` +
+				`{"container":"","variable":"GOMAXPROCS","action":"injected","value":"3","strategy":"floor","reason":"cpu-request"},` +
+				`{"container":"","variable":"GOMAXPROCS","action":"injected","value":"6","strategy":"floor","reason":"cpu-request"},` +
+				`{"container":"container-with-cpu-request-and-limit","variable":"GOMAXPROCS","action":"injected","value":"8","cpuLimit":"8","strategy":"floor","reason":"cpu-limit"}]`,
+		},
+		{
+			desc: "test pod with pod-level cpu limit",
+			review: v1.AdmissionReview{
+				Request: &v1.AdmissionRequest{
+					Resource: metav1.GroupVersionResource{
+						Group:    "",
+						Version:  "v1",
+						Resource: "pods",
+					},
+					Object: newPodObjectFromPod(t, &corev1.Pod{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "test-pod-with-pod-level-resources",
+							Namespace: "default",
+						},
+						Spec: corev1.PodSpec{
+							Resources: &corev1.ResourceRequirements{
+								Limits: corev1.ResourceList{
+									corev1.ResourceCPU: resource.MustParse("3"),
+								},
+							},
+							InitContainers: []corev1.Container{
+								{
+									Name: "init-container-without-cpu-limit",
+								},
+							},
+							Containers: []corev1.Container{
+								{
+									Name: "container-without-cpu-limit",
+								},
+								containerWithCPULimit("2"),
+								containerWithCPULimit("4"),
+								containerWithCPURequest("1"),
+							},
+						},
+					}),
+				},
+			},
+			allowed: true,
+			expectedInitContainersGOMAXPROCS: []int{
+				3, // init-container-without-cpu-limit
+			},
+			expectedContainersGOMAXPROCS: []int{
+				3, // container-without-cpu-limit
+				2,
+				3,
+				3,
+			},
+			expectedDecisions: `[{"container":"init-container-without-cpu-limit","variable":"GOMAXPROCS","action":"injected","value":"3","cpuLimit":"3","strategy":"floor","reason":"cpu-limit"},` +
+				`{"container":"container-without-cpu-limit","variable":"GOMAXPROCS","action":"injected","value":"3","cpuLimit":"3","strategy":"floor","reason":"cpu-limit"},` +
+				`{"container":"","variable":"GOMAXPROCS","action":"injected","value":"2","cpuLimit":"2","strategy":"floor","reason":"cpu-limit"},` +
+				`{"container":"","variable":"GOMAXPROCS","action":"injected","value":"3","cpuLimit":"3","strategy":"floor","reason":"cpu-limit"},` +
+				`{"container":"","variable":"GOMAXPROCS","action":"injected","value":"3","cpuLimit":"3","strategy":"floor","reason":"cpu-limit"}]`,
+		},
+		{
+			desc: "test pod with pod-level cpu request only",
+			review: v1.AdmissionReview{
+				Request: &v1.AdmissionRequest{
+					Resource: metav1.GroupVersionResource{
+						Group:    "",
+						Version:  "v1",
+						Resource: "pods",
+					},
+					Object: newPodObjectFromPod(t, &corev1.Pod{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "test-pod-with-pod-level-requests",
+							Namespace: "default",
+						},
+						Spec: corev1.PodSpec{
+							Resources: &corev1.ResourceRequirements{
+								Requests: corev1.ResourceList{
+									corev1.ResourceCPU: resource.MustParse("3"),
+								},
+							},
+							Containers: []corev1.Container{
+								{
+									Name: "container-without-cpu-limit",
+								},
+								containerWithCPULimit("2"),
+							},
+						},
+					}),
+				},
+			},
+			allowed: true,
+			expectedContainersGOMAXPROCS: []int{
+				0, // container-without-cpu-limit
+				2,
+			},
+			expectedDecisions: `[{"container":"container-without-cpu-limit","variable":"GOMAXPROCS","action":"skipped","reason":"no-cpu-limit"},` +
+				`{"container":"","variable":"GOMAXPROCS","action":"injected","value":"2","cpuLimit":"2","strategy":"floor","reason":"cpu-limit"}]`,
+		},
+		{
+			desc: "test pod with sidecar and skipped init containers",
+			config: &Config{
+				GOMAXPROCSEnabled: true,
+				Strategy:          gomaxprocs.Floor(),
+				InitContainers:    InitContainerPolicySkip,
+			},
+			review: v1.AdmissionReview{
+				Request: &v1.AdmissionRequest{
+					Resource: metav1.GroupVersionResource{
+						Group:    "",
+						Version:  "v1",
+						Resource: "pods",
+					},
+					Object: newPodObjectFromPod(t, &corev1.Pod{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "test-pod-with-sidecar",
+							Namespace: "default",
+						},
+						Spec: corev1.PodSpec{
+							InitContainers: []corev1.Container{
+								containerWithCPULimit("2"),
+								sidecarContainer(containerWithCPULimit("2")),
+							},
+							Containers: []corev1.Container{
+								containerWithCPULimit("3"),
+							},
+						},
+					}),
+				},
+			},
+			allowed: true,
+			expectedInitContainersGOMAXPROCS: []int{
+				0, // regular init container
+				2, // sidecar
+			},
+			expectedContainersGOMAXPROCS: []int{
+				3,
+			},
+			expectedDecisions: `[{"container":"","action":"skipped","reason":"init-container-policy"},` +
+				`{"container":"","variable":"GOMAXPROCS","action":"injected","value":"2","cpuLimit":"2","strategy":"floor","reason":"cpu-limit"},` +
+				`{"container":"","variable":"GOMAXPROCS","action":"injected","value":"3","cpuLimit":"3","strategy":"floor","reason":"cpu-limit"}]`,
+		},
+		{
+			desc: "should not accept a subresource other than ephemeralcontainers",
+			review: v1.AdmissionReview{
+				Request: &v1.AdmissionRequest{
+					Resource: metav1.GroupVersionResource{
+						Group:    "",
+						Version:  "v1",
+						Resource: "pods",
+					},
+					SubResource: "status",
+					Operation:   v1.Update,
+				},
+			},
+			allowed: false,
+		},
+		{
+			desc: "test pod with per-container annotations",
+			review: v1.AdmissionReview{
+				Request: &v1.AdmissionRequest{
+					Resource: metav1.GroupVersionResource{
+						Group:    "",
+						Version:  "v1",
+						Resource: "pods",
+					},
+					Object: newPodObjectFromPod(t, &corev1.Pod{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "test-pod-with-container-annotations",
+							Namespace: "default",
+							Annotations: map[string]string{
+								"gomaxprocs-injector/inject.sidecar":   "disabled",
+								"gomaxprocs-injector/inject.app":       "enabled",
+								"gomaxprocs-injector/value.app":        "4",
+								"gomaxprocs-injector/value.no-limit":   "3",
+								"gomaxprocs-injector/value.user-value": "3",
+							},
+						},
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{
+								namedContainer("sidecar", containerWithCPULimit("2")),
+								namedContainer("app", containerWithCPULimit("2")),
+								namedContainer("no-limit", corev1.Container{}),
+								{
+									Name: "user-value",
+									Env: []corev1.EnvVar{
+										{
+											Name:  "GOMAXPROCS",
+											Value: "1",
+										},
+									},
+								},
+								namedContainer("other", containerWithCPULimit("2")),
+							},
+						},
+					}),
+				},
+			},
+			allowed: true,
+			expectedContainersGOMAXPROCS: []int{
+				0, // sidecar
+				4, // app
+				3, // no-limit
+				1, // user-value
+				2, // other
+			},
+			expectedDecisions: `[{"container":"sidecar","action":"skipped","reason":"container-disabled"},` +
+				`{"container":"app","variable":"GOMAXPROCS","action":"injected","value":"4","cpuLimit":"2","reason":"annotation"},` +
+				`{"container":"no-limit","variable":"GOMAXPROCS","action":"injected","value":"3","reason":"annotation"},` +
+				`{"container":"user-value","variable":"GOMAXPROCS","action":"kept","value":"1","reason":"user-set"},` +
+				`{"container":"other","variable":"GOMAXPROCS","action":"injected","value":"2","cpuLimit":"2","strategy":"floor","reason":"cpu-limit"}]`,
+		},
+		{
+			desc: "should deny an invalid value annotation",
+			review: v1.AdmissionReview{
+				Request: &v1.AdmissionRequest{
+					Resource: metav1.GroupVersionResource{
+						Group:    "",
+						Version:  "v1",
+						Resource: "pods",
+					},
+					Object: newPodObjectFromPod(t, &corev1.Pod{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "test-pod-with-invalid-value-annotation",
+							Annotations: map[string]string{
+								"gomaxprocs-injector/value.app": "four",
+							},
+						},
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{
+								namedContainer("app", containerWithCPULimit("2")),
+							},
+						},
+					}),
+				},
+			},
+			allowed: false,
+		},
+		{
+			desc: "should deny an invalid inject annotation",
+			review: v1.AdmissionReview{
+				Request: &v1.AdmissionRequest{
+					Resource: metav1.GroupVersionResource{
+						Group:    "",
+						Version:  "v1",
+						Resource: "pods",
+					},
+					Object: newPodObjectFromPod(t, &corev1.Pod{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "test-pod-with-invalid-inject-annotation",
+							Annotations: map[string]string{
+								"gomaxprocs-injector/inject.app": "off",
+							},
+						},
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{
+								namedContainer("app", containerWithCPULimit("2")),
+							},
+						},
+					}),
+				},
+			},
+			allowed: false,
 		},
 		{
 			desc: "injection disabled pod",
@@ -182,22 +553,26 @@ func TestAdmit(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run("v1 "+tc.desc, func(t *testing.T) {
-			c := NewController()
-			res := c.admit(tc.review)
+			c := newTestController(tc.config)
+			res := c.admit(context.Background(), tc.review)
 			if res.Allowed != tc.allowed {
 				t.Errorf("expected %v, got %v", tc.allowed, res.Allowed)
 			}
 			if tc.allowed {
-				checkGOMAXPROCS(t,
-					tc.review.Request.Object.Raw,
-					res.Patch,
-					tc.expectedInitContainersGOMAXPROCS,
-					tc.expectedContainersGOMAXPROCS)
+				checkPatch(t, tc.review.Request.Object.Raw, res.Patch, func(expectedPod *corev1.Pod) {
+					expectGOMAXPROCS(expectedPod, tc.expectedInitContainersGOMAXPROCS, tc.expectedContainersGOMAXPROCS)
+					if tc.expectedDecisions != "" {
+						if expectedPod.Annotations == nil {
+							expectedPod.Annotations = map[string]string{}
+						}
+						expectedPod.Annotations[decisionsAnnotationKey] = tc.expectedDecisions
+					}
+				})
 			}
 		})
 		t.Run("v1beta1 "+tc.desc, func(t *testing.T) {
-			c := NewController()
-			res := c.admitV1beta1(v1beta1.AdmissionReview{
+			c := newTestController(tc.config)
+			res := c.admitV1beta1(context.Background(), v1beta1.AdmissionReview{
 				Request: convertAdmissionRequestToV1beta1(tc.review.Request),
 			})
 			if res.Allowed != tc.allowed {
@@ -208,6 +583,397 @@ func TestAdmit(t *testing.T) {
 	}
 }
 
+func TestAdmitGOMEMLIMIT(t *testing.T) {
+	config := DefaultConfig()
+	config.GOMAXPROCSEnabled = false
+	config.GOMEMLIMIT.Enabled = true
+
+	review := v1.AdmissionReview{
+		Request: &v1.AdmissionRequest{
+			Resource: metav1.GroupVersionResource{
+				Group:    "",
+				Version:  "v1",
+				Resource: "pods",
+			},
+			Object: newTestPodObject(t,
+				"test-pod",
+				containerWithCPULimit("2"),
+				containerWithMemoryLimit("1Gi"),
+				corev1.Container{
+					Name: "container-with-GOMEMLIMIT",
+					Env: []corev1.EnvVar{
+						{
+							Name:  "GOMEMLIMIT",
+							Value: "100MiB",
+						},
+					},
+					Resources: corev1.ResourceRequirements{
+						Limits: corev1.ResourceList{
+							corev1.ResourceMemory: resource.MustParse("1Gi"),
+						},
+					},
+				},
+			),
+		},
+	}
+
+	res := NewController(config).admit(context.Background(), review)
+	if !res.Allowed {
+		t.Fatalf("expected allowed, got %v", res.Result)
+	}
+
+	checkPatch(t, review.Request.Object.Raw, res.Patch, func(expectedPod *corev1.Pod) {
+		expectedPod.Spec.Containers[1].Env = append(expectedPod.Spec.Containers[1].Env, corev1.EnvVar{
+			Name:  "GOMEMLIMIT",
+			Value: "966367641",
+		})
+	})
+}
+
+func TestAdmitEphemeralContainers(t *testing.T) {
+	oldPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-pod",
+			Namespace: "default",
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				containerWithCPULimit("1"),
+				containerWithCPULimit("2500m"),
+			},
+			EphemeralContainers: []corev1.EphemeralContainer{
+				{
+					EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+						Name: "debugger-old",
+					},
+				},
+			},
+		},
+	}
+	pod := oldPod.DeepCopy()
+	pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name: "debugger-new",
+		},
+	})
+
+	review := v1.AdmissionReview{
+		Request: &v1.AdmissionRequest{
+			Resource: metav1.GroupVersionResource{
+				Group:    "",
+				Version:  "v1",
+				Resource: "pods",
+			},
+			SubResource: "ephemeralcontainers",
+			Operation:   v1.Update,
+			Object:      newPodObjectFromPod(t, pod),
+			OldObject:   newPodObjectFromPod(t, oldPod),
+		},
+	}
+
+	res := NewController(DefaultConfig()).admit(context.Background(), review)
+	if !res.Allowed {
+		t.Fatalf("expected allowed, got %v", res.Result)
+	}
+
+	checkPatch(t, review.Request.Object.Raw, res.Patch, func(expectedPod *corev1.Pod) {
+		expectedPod.Spec.EphemeralContainers[1].Env = []corev1.EnvVar{
+			{
+				Name:  "GOMAXPROCS",
+				Value: "3",
+			},
+		}
+	})
+}
+
+func TestIsInjectionEnabled(t *testing.T) {
+	podAnnotations := []struct {
+		desc        string
+		annotations map[string]string
+	}{
+		{"no pod annotation", nil},
+		{"pod enabled", map[string]string{"gomaxprocs-injector/inject": "enabled"}},
+		{"pod disabled", map[string]string{"gomaxprocs-injector/inject": "disabled"}},
+		{"pod other", map[string]string{"gomaxprocs-injector/inject": "other"}},
+	}
+	namespaces := []struct {
+		desc      string
+		namespace *corev1.Namespace
+	}{
+		{"no namespace", nil},
+		{"no namespace label", &corev1.Namespace{}},
+		{"namespace enabled", &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"gomaxprocs-injector/inject": "enabled"}}}},
+		{"namespace disabled", &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"gomaxprocs-injector/inject": "disabled"}}}},
+	}
+	// expected[mode][pod annotation][namespace]
+	expected := map[InjectionMode][4][4]bool{
+		InjectionModeOptOut: {
+			{true, true, true, false},
+			{true, true, true, true},
+			{false, false, false, false},
+			{true, true, true, true},
+		},
+		InjectionModeOptIn: {
+			{false, false, true, false},
+			{true, true, true, true},
+			{false, false, false, false},
+			{false, false, false, false},
+		},
+	}
+
+	for mode, expectedForMode := range expected {
+		for i, pa := range podAnnotations {
+			for j, ns := range namespaces {
+				pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: pa.annotations}}
+				config := Config{InjectionMode: mode, InjectAnnotationKey: injectAnnotationKey}
+				if got := isInjectionEnabled(config, pod, ns.namespace); got != expectedForMode[i][j] {
+					t.Errorf("%s, %s, %s: expected %v, got %v", mode, pa.desc, ns.desc, expectedForMode[i][j], got)
+				}
+			}
+		}
+	}
+
+	// With another inject annotation key, the default one means nothing.
+	config := Config{InjectionMode: InjectionModeOptOut, InjectAnnotationKey: "example.com/gomaxprocs"}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"gomaxprocs-injector/inject": "disabled"}}}
+	if !isInjectionEnabled(config, pod, nil) {
+		t.Errorf("expected the default annotation to be ignored")
+	}
+	pod.Annotations = map[string]string{"example.com/gomaxprocs": "disabled"}
+	if isInjectionEnabled(config, pod, nil) {
+		t.Errorf("expected the configured annotation to disable injection")
+	}
+}
+
+func TestAdmitOptIn(t *testing.T) {
+	namespaces := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, namespace := range []*corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "enabled", Labels: map[string]string{"gomaxprocs-injector/inject": "enabled"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "unlabeled"}},
+	} {
+		if err := namespaces.Add(namespace); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := DefaultConfig()
+	config.InjectionMode = InjectionModeOptIn
+	c := NewController(config, WithNamespaceLister(corelisters.NewNamespaceLister(namespaces)))
+
+	for _, tc := range []struct {
+		namespace string
+		expected  int
+	}{
+		{namespace: "enabled", expected: 2},
+		{namespace: "unlabeled", expected: 0},
+		{namespace: "missing", expected: 0},
+	} {
+		t.Run(tc.namespace, func(t *testing.T) {
+			review := v1.AdmissionReview{
+				Request: &v1.AdmissionRequest{
+					Resource: metav1.GroupVersionResource{
+						Group:    "",
+						Version:  "v1",
+						Resource: "pods",
+					},
+					Namespace: tc.namespace,
+					Object: newPodObjectFromPod(t, &corev1.Pod{
+						ObjectMeta: metav1.ObjectMeta{Name: "test-pod"},
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{
+								containerWithCPULimit("2"),
+							},
+						},
+					}),
+				},
+			}
+			res := c.admit(context.Background(), review)
+			if !res.Allowed {
+				t.Fatalf("expected allowed, got %v", res.Result)
+			}
+			checkGOMAXPROCS(t, review.Request.Object.Raw, res.Patch, nil, []int{tc.expected})
+		})
+	}
+}
+
+func TestAdmitEnforce(t *testing.T) {
+	config := DefaultConfig()
+	config.Enforce = true
+
+	withGOMAXPROCS := func(container corev1.Container, name, value string) corev1.Container {
+		container.Name = name
+		container.Env = []corev1.EnvVar{
+			{
+				Name:  "GOMAXPROCS",
+				Value: value,
+			},
+		}
+		return container
+	}
+	review := v1.AdmissionReview{
+		Request: &v1.AdmissionRequest{
+			Resource: metav1.GroupVersionResource{
+				Group:    "",
+				Version:  "v1",
+				Resource: "pods",
+			},
+			Object: newTestPodObject(t,
+				"test-pod",
+				withGOMAXPROCS(containerWithCPULimit("2"), "too-large", "64"),
+				withGOMAXPROCS(containerWithCPULimit("2"), "not-an-integer", "two"),
+				withGOMAXPROCS(containerWithCPULimit("2"), "empty", ""),
+				withGOMAXPROCS(containerWithCPULimit("2"), "zero", "0"),
+				withGOMAXPROCS(containerWithCPULimit("2"), "within-limit", "1"),
+				withGOMAXPROCS(corev1.Container{}, "without-cpu-limit", "64"),
+				corev1.Container{
+					Name: "from-field",
+					Env: []corev1.EnvVar{
+						{
+							Name: "GOMAXPROCS",
+							ValueFrom: &corev1.EnvVarSource{
+								ResourceFieldRef: &corev1.ResourceFieldSelector{Resource: "limits.cpu"},
+							},
+						},
+					},
+					Resources: corev1.ResourceRequirements{
+						Limits: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("2"),
+						},
+					},
+				},
+			),
+		},
+	}
+
+	res := NewController(config).admit(context.Background(), review)
+	if !res.Allowed {
+		t.Fatalf("expected allowed, got %v", res.Result)
+	}
+	if len(res.Warnings) != 4 {
+		t.Errorf("expected 4 warnings, got %v", res.Warnings)
+	}
+
+	checkPatch(t, review.Request.Object.Raw, res.Patch, func(expectedPod *corev1.Pod) {
+		for i := 0; i < 4; i++ {
+			expectedPod.Spec.Containers[i].Env[0].Value = "2"
+		}
+		expectedPod.Annotations = map[string]string{
+			"gomaxprocs-injector/overridden": `{"empty":{"value":"","reason":"\"\" is not a positive integer"},` +
+				`"not-an-integer":{"value":"two","reason":"\"two\" is not a positive integer"},` +
+				`"too-large":{"value":"64","reason":"64 exceeds the maximum of 2 for the cpu resource limit"},` +
+				`"zero":{"value":"0","reason":"\"0\" is not a positive integer"}}`,
+			"gomaxprocs-injector/decisions": `[{"container":"too-large","variable":"GOMAXPROCS","action":"overridden","value":"2","previous":"64","cpuLimit":"2","strategy":"floor","reason":"exceeds-limit"},` +
+				`{"container":"not-an-integer","variable":"GOMAXPROCS","action":"overridden","value":"2","previous":"two","cpuLimit":"2","strategy":"floor","reason":"not-positive-integer"},` +
+				`{"container":"empty","variable":"GOMAXPROCS","action":"overridden","value":"2","cpuLimit":"2","strategy":"floor","reason":"not-positive-integer"},` +
+				`{"container":"zero","variable":"GOMAXPROCS","action":"overridden","value":"2","previous":"0","cpuLimit":"2","strategy":"floor","reason":"not-positive-integer"},` +
+				`{"container":"within-limit","variable":"GOMAXPROCS","action":"kept","value":"1","cpuLimit":"2","reason":"within-limit"},` +
+				`{"container":"without-cpu-limit","variable":"GOMAXPROCS","action":"kept","value":"64","reason":"no-cpu-limit"},` +
+				`{"container":"from-field","variable":"GOMAXPROCS","action":"kept","cpuLimit":"2","reason":"reference"}]`,
+		}
+	})
+
+	// On reinvocation, the overridden values and their decisions are kept.
+	decoded, err := jsonpatch.DecodePatch(res.Patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	review.Request.Object.Raw, err = decoded.Apply(review.Request.Object.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pod corev1.Pod
+	if err := json.Unmarshal(review.Request.Object.Raw, &pod); err != nil {
+		t.Fatal(err)
+	}
+	var decisions []decision
+	if err := json.Unmarshal([]byte(pod.Annotations[decisionsAnnotationKey]), &decisions); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range decisions[:4] {
+		if d.Action != decisionOverridden {
+			t.Errorf("expected container %q to be overridden, got %v", d.Container, d)
+		}
+	}
+
+	res = NewController(config).admit(context.Background(), review)
+	if !res.Allowed {
+		t.Fatalf("expected allowed on reinvocation, got %v", res.Result)
+	}
+	if res.Patch != nil {
+		t.Errorf("expected no patch on reinvocation, got %s", res.Patch)
+	}
+}
+
+func TestServeHTTPMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	c := NewController(DefaultConfig(), WithMetrics(metrics.New(registry)))
+
+	for _, review := range []runtime.Object{
+		&v1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+			Request: &v1.AdmissionRequest{
+				UID:       "1",
+				Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+				Namespace: "default",
+				Object:    newTestPodObject(t, "test-pod", containerWithCPULimit("2")),
+			},
+		},
+		&v1beta1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1beta1", Kind: "AdmissionReview"},
+			Request: &v1beta1.AdmissionRequest{
+				UID:       "2",
+				Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "configmaps"},
+				Namespace: "default",
+			},
+		},
+	} {
+		c.ServeHTTP(httptest.NewRecorder(), newReviewRequest(t, review))
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/mutate", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "text/plain")
+	c.ServeHTTP(httptest.NewRecorder(), req)
+
+	expected := `
+# HELP gomaxprocs_injector_admission_requests_total Number of admission requests by webhook, AdmissionReview version, result and namespace.
+# TYPE gomaxprocs_injector_admission_requests_total counter
+gomaxprocs_injector_admission_requests_total{api_version="unknown",namespace="",result="error",webhook="mutating"} 1
+gomaxprocs_injector_admission_requests_total{api_version="v1",namespace="default",result="patched",webhook="mutating"} 1
+gomaxprocs_injector_admission_requests_total{api_version="v1beta1",namespace="default",result="denied",webhook="mutating"} 1
+# HELP gomaxprocs_injector_container_decisions_total Number of decisions taken for containers by variable, action, reason and mode.
+# TYPE gomaxprocs_injector_container_decisions_total counter
+gomaxprocs_injector_container_decisions_total{action="injected",mode="mutate",reason="cpu-limit",variable="GOMAXPROCS"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"gomaxprocs_injector_admission_requests_total",
+		"gomaxprocs_injector_container_decisions_total",
+	); err != nil {
+		t.Error(err)
+	}
+
+	if count, err := testutil.GatherAndCount(registry, "gomaxprocs_injector_patch_size_bytes"); err != nil || count != 1 {
+		t.Errorf("expected one patch size histogram, got %d: %v", count, err)
+	}
+}
+
+// newReviewRequest returns a request that posts review to the webhook.
+func newReviewRequest(t *testing.T, review runtime.Object) *http.Request {
+	body, err := json.Marshal(review)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func newTestController(config *Config) *Controller {
+	if config == nil {
+		return NewController(DefaultConfig())
+	}
+	return NewController(*config)
+}
+
 func newTestPodObject(t *testing.T, name string, containers ...corev1.Container) runtime.RawExtension {
 	pod := &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
@@ -243,13 +1009,45 @@ func containerWithCPULimit(cpu string) corev1.Container {
 	}
 }
 
-func checkGOMAXPROCS(t *testing.T, rawObject, patch []byte, expectedInitContainersGOMAXPROCS, expectedContainersGOMAXPROCS []int) {
-	var pod corev1.Pod
-	if err := json.Unmarshal(rawObject, &pod); err != nil {
-		t.Fatal(err)
+func namedContainer(name string, container corev1.Container) corev1.Container {
+	container.Name = name
+	return container
+}
+
+func sidecarContainer(container corev1.Container) corev1.Container {
+	restartPolicy := corev1.ContainerRestartPolicyAlways
+	container.RestartPolicy = &restartPolicy
+	return container
+}
+
+func containerWithMemoryLimit(memory string) corev1.Container {
+	return corev1.Container{
+		Resources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{
+				corev1.ResourceMemory: resource.MustParse(memory),
+			},
+		},
 	}
+}
 
-	expectedPod := pod.DeepCopy()
+func containerWithCPURequest(cpu string) corev1.Container {
+	return corev1.Container{
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse(cpu),
+			},
+		},
+	}
+}
+
+func checkGOMAXPROCS(t *testing.T, rawObject, patch []byte, expectedInitContainersGOMAXPROCS, expectedContainersGOMAXPROCS []int) {
+	checkPatch(t, rawObject, patch, func(expectedPod *corev1.Pod) {
+		expectGOMAXPROCS(expectedPod, expectedInitContainersGOMAXPROCS, expectedContainersGOMAXPROCS)
+	})
+}
+
+// expectGOMAXPROCS sets GOMAXPROCS in the containers of expectedPod.
+func expectGOMAXPROCS(expectedPod *corev1.Pod, expectedInitContainersGOMAXPROCS, expectedContainersGOMAXPROCS []int) {
 	for i := range expectedPod.Spec.InitContainers {
 		expectedPod.Spec.InitContainers[i].Env = applyGOMAXPROCSToEnv(expectedPod.Spec.InitContainers[i].Env, expectedInitContainersGOMAXPROCS[i])
 	}
@@ -257,6 +1055,25 @@ func checkGOMAXPROCS(t *testing.T, rawObject, patch []byte, expectedInitContaine
 	for i := range expectedPod.Spec.Containers {
 		expectedPod.Spec.Containers[i].Env = applyGOMAXPROCSToEnv(expectedPod.Spec.Containers[i].Env, expectedContainersGOMAXPROCS[i])
 	}
+}
+
+// checkPatch verifies that patch is the JSONPatch that turns rawObject into
+// the pod produced by mutate. Unless mutate sets it, the injected annotation
+// is expected to record the variables mutate added or changed.
+func checkPatch(t *testing.T, rawObject, patch []byte, mutate func(expectedPod *corev1.Pod)) {
+	var pod corev1.Pod
+	if err := json.Unmarshal(rawObject, &pod); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedPod := pod.DeepCopy()
+	mutate(expectedPod)
+	if expectedPod.Annotations[injectedAnnotationKey] == pod.Annotations[injectedAnnotationKey] {
+		expectInjected(t, &pod, expectedPod)
+	}
+	if expectedPod.Annotations[decisionsAnnotationKey] == pod.Annotations[decisionsAnnotationKey] {
+		expectDecisions(t, rawObject, patch, expectedPod)
+	}
 
 	expectedPatch, err := jsondiff.Compare(&pod, expectedPod)
 	if err != nil {
@@ -283,6 +1100,75 @@ func checkGOMAXPROCS(t *testing.T, rawObject, patch []byte, expectedInitContaine
 	}
 }
 
+// expectInjected sets the injected annotation of expectedPod to the variables
+// that differ from pod.
+func expectInjected(t *testing.T, pod, expectedPod *corev1.Pod) {
+	injected := injectedValues{}
+	record := func(container, expectedContainer *corev1.Container) {
+		for _, name := range []string{gomaxprocsEnvName, gomemlimitEnvName} {
+			expected := findEnv(expectedContainer, name)
+			if expected == nil {
+				continue
+			}
+			if original := findEnv(container, name); original != nil && original.Value == expected.Value {
+				continue
+			}
+			if injected[expectedContainer.Name] == nil {
+				injected[expectedContainer.Name] = map[string]string{}
+			}
+			injected[expectedContainer.Name][name] = expected.Value
+		}
+	}
+	for i := range expectedPod.Spec.InitContainers {
+		record(&pod.Spec.InitContainers[i], &expectedPod.Spec.InitContainers[i])
+	}
+	for i := range expectedPod.Spec.Containers {
+		record(&pod.Spec.Containers[i], &expectedPod.Spec.Containers[i])
+	}
+	if len(injected) == 0 {
+		return
+	}
+
+	value, err := json.Marshal(injected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expectedPod.Annotations == nil {
+		expectedPod.Annotations = map[string]string{}
+	}
+	expectedPod.Annotations[injectedAnnotationKey] = string(value)
+}
+
+// expectDecisions sets the decisions annotation of expectedPod to the one the
+// patch sets, for tests that do not check the decisions themselves.
+func expectDecisions(t *testing.T, rawObject, patch []byte, expectedPod *corev1.Pod) {
+	if patch == nil {
+		return
+	}
+	decoded, err := jsonpatch.DecodePatch(patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched, err := decoded.Apply(rawObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pod corev1.Pod
+	if err := json.Unmarshal(patched, &pod); err != nil {
+		t.Fatal(err)
+	}
+
+	value, ok := pod.Annotations[decisionsAnnotationKey]
+	if !ok {
+		delete(expectedPod.Annotations, decisionsAnnotationKey)
+		return
+	}
+	if expectedPod.Annotations == nil {
+		expectedPod.Annotations = map[string]string{}
+	}
+	expectedPod.Annotations[decisionsAnnotationKey] = value
+}
+
 func applyGOMAXPROCSToEnv(env []corev1.EnvVar, gomaxprocs int) []corev1.EnvVar {
 	if gomaxprocs == 0 {
 		return env
@@ -0,0 +1,238 @@
+package admission
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/klog/v2"
+)
+
+// CustomResource maps a kind of custom resource to the pod specs it embeds.
+type CustomResource struct {
+	Group   string
+	Version string
+	Kind    string
+	// PodSpecPaths are the dot-separated paths of the pod specs in the
+	// object, such as "spec.template.spec". If the last field of a path is
+	// "spec", the "metadata" next to it is taken as the metadata of the pods.
+	PodSpecPaths []string
+}
+
+// GroupVersionKind returns the group, version and kind of the custom resource.
+func (r CustomResource) GroupVersionKind() metav1.GroupVersionKind {
+	return metav1.GroupVersionKind{Group: r.Group, Version: r.Version, Kind: r.Kind}
+}
+
+// Validate checks that the custom resource and its paths are complete.
+func (r CustomResource) Validate() error {
+	if r.Version == "" || r.Kind == "" {
+		return fmt.Errorf("custom resource %s must have a version and a kind", r.GroupVersionKind())
+	}
+	if len(r.PodSpecPaths) == 0 {
+		return fmt.Errorf("custom resource %s must have at least one pod spec path", r.GroupVersionKind())
+	}
+	for _, path := range r.PodSpecPaths {
+		for _, field := range strings.Split(path, ".") {
+			if field == "" {
+				return fmt.Errorf("custom resource %s has invalid pod spec path %q", r.GroupVersionKind(), path)
+			}
+		}
+	}
+	return nil
+}
+
+// customResource returns the configured custom resource of the kind.
+func (c Config) customResource(gvk metav1.GroupVersionKind) (CustomResource, bool) {
+	for _, cr := range c.CustomResources {
+		if cr.GroupVersionKind() == gvk {
+			return cr, true
+		}
+	}
+	return CustomResource{}, false
+}
+
+// admitCustomResource mutates the pod specs embedded in a custom resource as
+// if they were pods. The object is handled as unstructured, and only the env
+// of the containers and the pod annotations are written back, so fields that
+// are not part of the pod spec are left as they are. On update, the pod specs
+// that are unchanged, along with their metadata, are left alone, as with the
+// pod templates of workloads.
+func (c *Controller) admitCustomResource(ctx context.Context, review v1.AdmissionReview, cr CustomResource) *v1.AdmissionResponse {
+	gvk := cr.GroupVersionKind()
+	if review.Request.SubResource != "" {
+		err := fmt.Errorf("unsupported operation %s on subresource %q of %s", review.Request.Operation, review.Request.SubResource, gvk)
+		klog.ErrorS(err, "Failed to admit")
+		return toV1AdmissionResponse(err)
+	}
+
+	// Unlike encoding/json, this decodes integers as int64, which the
+	// unstructured helpers expect.
+	var obj map[string]interface{}
+	if err := utiljson.Unmarshal(review.Request.Object.Raw, &obj); err != nil {
+		klog.ErrorS(err, "Failed to unmarshal custom resource", "kind", gvk)
+		return toV1AdmissionResponse(err)
+	}
+	newObj := runtime.DeepCopyJSON(obj)
+	var oldObj map[string]interface{}
+	if review.Request.Operation == v1.Update {
+		if err := utiljson.Unmarshal(review.Request.OldObject.Raw, &oldObj); err != nil {
+			klog.ErrorS(err, "Failed to unmarshal old custom resource", "kind", gvk)
+			return toV1AdmissionResponse(err)
+		}
+	}
+
+	ref := klog.KRef(review.Request.Namespace, review.Request.Name)
+	klog.InfoS("Admitting a custom resource", "kind", gvk, "object", ref)
+
+	var o outcome
+	for _, path := range cr.PodSpecPaths {
+		if oldObj != nil && !embeddedPodChanged(oldObj, obj, path) {
+			klog.InfoS("Skipping unchanged pod spec", "kind", gvk, "object", ref, "path", path)
+			continue
+		}
+		m, err := c.mutateEmbeddedPodSpec(review, newObj, path)
+		if err != nil {
+			err = fmt.Errorf("pod spec at %s: %w", path, err)
+			klog.ErrorS(err, "Failed to mutate custom resource", "kind", gvk, "object", ref)
+			return toV1AdmissionResponse(err)
+		}
+		if m != nil {
+			mo := m.outcome()
+			o.warnings = append(o.warnings, mo.warnings...)
+			o.decisions = append(o.decisions, mo.decisions...)
+			o.reportOnly = o.reportOnly || mo.reportOnly
+		}
+	}
+
+	return c.patchResponse(ctx, obj, newObj, o, "object", ref)
+}
+
+// mutateEmbeddedPodSpec mutates the pod spec at the path in obj and returns
+// the mutation, or nil if there was nothing to mutate. A missing pod spec is
+// skipped, as the path may be optional.
+func (c *Controller) mutateEmbeddedPodSpec(review v1.AdmissionReview, obj map[string]interface{}, path string) (*mutation, error) {
+	fields := strings.Split(path, ".")
+	rawSpec, found, err := unstructured.NestedMap(obj, fields...)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		klog.V(2).InfoS("Skipping missing pod spec", "path", path)
+		return nil, nil
+	}
+
+	pod := &corev1.Pod{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(rawSpec, &pod.Spec); err != nil {
+		return nil, err
+	}
+	metadataFields := embeddedMetadataFields(fields)
+	if metadataFields != nil {
+		rawMetadata, found, err := unstructured.NestedMap(obj, metadataFields...)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(rawMetadata, &pod.ObjectMeta); err != nil {
+				return nil, err
+			}
+		}
+	}
+	pod.Namespace = review.Request.Namespace
+	pod.Name = review.Request.Name
+
+	newPod := pod.DeepCopy()
+	m, err := c.newPodMutation(newPod)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		klog.InfoS("Skipping pod spec as injection is disabled", "path", path)
+		return nil, nil
+	}
+	m.path = path
+	if err := c.mutatePodSpec(m); err != nil {
+		return nil, err
+	}
+
+	if err := setEmbeddedEnv(obj, fields, "initContainers", pod.Spec.InitContainers, newPod.Spec.InitContainers); err != nil {
+		return nil, err
+	}
+	if err := setEmbeddedEnv(obj, fields, "containers", pod.Spec.Containers, newPod.Spec.Containers); err != nil {
+		return nil, err
+	}
+	if metadataFields != nil && !reflect.DeepEqual(pod.Annotations, newPod.Annotations) {
+		annotations := make(map[string]interface{}, len(newPod.Annotations))
+		for k, v := range newPod.Annotations {
+			annotations[k] = v
+		}
+		if err := unstructured.SetNestedMap(obj, annotations, append(metadataFields, "annotations")...); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// embeddedMetadataFields returns the fields of the metadata of the pods whose
+// spec is at fields, or nil if the pod spec has no metadata next to it.
+func embeddedMetadataFields(fields []string) []string {
+	if fields[len(fields)-1] != "spec" {
+		return nil
+	}
+	return append(append([]string{}, fields[:len(fields)-1]...), "metadata")
+}
+
+// embeddedPodChanged tells whether the pod spec at the path, or its metadata,
+// differs between oldObj and obj.
+func embeddedPodChanged(oldObj, obj map[string]interface{}, path string) bool {
+	fields := strings.Split(path, ".")
+	for _, f := range [][]string{fields, embeddedMetadataFields(fields)} {
+		if f == nil {
+			continue
+		}
+		oldValue, oldFound, _ := unstructured.NestedFieldNoCopy(oldObj, f...)
+		value, found, _ := unstructured.NestedFieldNoCopy(obj, f...)
+		if oldFound != found || !reflect.DeepEqual(oldValue, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// setEmbeddedEnv writes the env of the containers that changed back into the
+// list of containers at the path in obj.
+func setEmbeddedEnv(obj map[string]interface{}, fields []string, list string, containers, newContainers []corev1.Container) error {
+	rawContainers, found, err := unstructured.NestedSlice(obj, append(fields, list)...)
+	if err != nil || !found {
+		return err
+	}
+
+	for i := range newContainers {
+		if reflect.DeepEqual(containers[i].Env, newContainers[i].Env) {
+			continue
+		}
+		rawContainer, ok := rawContainers[i].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s[%d] is not an object", list, i)
+		}
+		env, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&struct {
+			Env []corev1.EnvVar `json:"env"`
+		}{newContainers[i].Env})
+		if err != nil {
+			return err
+		}
+		if newContainers[i].Env == nil {
+			delete(rawContainer, "env")
+		} else {
+			rawContainer["env"] = env["env"]
+		}
+	}
+	return unstructured.SetNestedSlice(obj, rawContainers, append(fields, list)...)
+}
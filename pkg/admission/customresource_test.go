@@ -0,0 +1,202 @@
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestAdmitCustomResources(t *testing.T) {
+	config := DefaultConfig()
+	config.CustomResources = []CustomResource{
+		{
+			Group:        "argoproj.io",
+			Version:      "v1alpha1",
+			Kind:         "Rollout",
+			PodSpecPaths: []string{"spec.template.spec"},
+		},
+		{
+			Group:        "example.com",
+			Version:      "v1",
+			Kind:         "Runner",
+			PodSpecPaths: []string{"spec.leader", "spec.workers.template.spec"},
+		},
+	}
+	c := NewController(config)
+
+	testCases := []struct {
+		desc string
+		kind metav1.GroupVersionKind
+		// oldObject is set for updates.
+		oldObject string
+		object    string
+
+		allowed       bool
+		expectedPatch []map[string]interface{}
+	}{
+		{
+			desc: "rollout",
+			kind: metav1.GroupVersionKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "Rollout"},
+			object: `{
+				"apiVersion": "argoproj.io/v1alpha1",
+				"kind": "Rollout",
+				"metadata": {"name": "test", "namespace": "default"},
+				"spec": {
+					"replicas": 3,
+					"strategy": {"canary": {"steps": [{"setWeight": 20}]}},
+					"template": {
+						"metadata": {"labels": {"app": "test"}},
+						"spec": {
+							"containers": [
+								{"name": "app", "image": "app", "ports": [{"containerPort": 8080}], "resources": {"limits": {"cpu": "2"}}},
+								{"name": "proxy", "image": "proxy", "env": [{"name": "GOMAXPROCS", "value": "1"}], "resources": {"limits": {"cpu": "2"}}}
+							]
+						}
+					}
+				}
+			}`,
+			allowed: true,
+			expectedPatch: []map[string]interface{}{
+				{"op": "add", "path": "/spec/template/metadata/annotations", "value": map[string]interface{}{
+					"gomaxprocs-injector/decisions": `[{"path":"spec.template.spec","container":"app","variable":"GOMAXPROCS","action":"injected","value":"2","cpuLimit":"2","strategy":"floor","reason":"cpu-limit"},` +
+						`{"path":"spec.template.spec","container":"proxy","variable":"GOMAXPROCS","action":"kept","value":"1","cpuLimit":"2","reason":"user-set"}]`,
+					"gomaxprocs-injector/injected": `{"app":{"GOMAXPROCS":"2"}}`,
+				}},
+				{"op": "add", "path": "/spec/template/spec/containers/0/env", "value": []interface{}{map[string]interface{}{"name": "GOMAXPROCS", "value": "2"}}},
+			},
+		},
+		{
+			desc: "several paths, with and without metadata",
+			kind: metav1.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Runner"},
+			object: `{
+				"apiVersion": "example.com/v1",
+				"kind": "Runner",
+				"metadata": {"name": "test", "namespace": "default"},
+				"spec": {
+					"leader": {
+						"containers": [{"name": "leader", "resources": {"limits": {"cpu": "1"}}}]
+					},
+					"workers": {
+						"template": {
+							"metadata": {"annotations": {"gomaxprocs-injector/inject.helper": "disabled"}},
+							"spec": {
+								"initContainers": [{"name": "init", "resources": {"limits": {"cpu": "3"}}}],
+								"containers": [
+									{"name": "worker", "resources": {"limits": {"cpu": "4"}}},
+									{"name": "helper", "resources": {"limits": {"cpu": "4"}}}
+								]
+							}
+						}
+					}
+				}
+			}`,
+			allowed: true,
+			expectedPatch: []map[string]interface{}{
+				{"op": "add", "path": "/spec/leader/containers/0/env", "value": []interface{}{map[string]interface{}{"name": "GOMAXPROCS", "value": "1"}}},
+				{"op": "add", "path": "/spec/workers/template/metadata/annotations/gomaxprocs-injector~1decisions", "value": `[{"path":"spec.workers.template.spec","container":"init","variable":"GOMAXPROCS","action":"injected","value":"3","cpuLimit":"3","strategy":"floor","reason":"cpu-limit"},` +
+					`{"path":"spec.workers.template.spec","container":"worker","variable":"GOMAXPROCS","action":"injected","value":"4","cpuLimit":"4","strategy":"floor","reason":"cpu-limit"},` +
+					`{"path":"spec.workers.template.spec","container":"helper","action":"skipped","reason":"container-disabled"}]`},
+				{"op": "add", "path": "/spec/workers/template/metadata/annotations/gomaxprocs-injector~1injected", "value": `{"init":{"GOMAXPROCS":"3"},"worker":{"GOMAXPROCS":"4"}}`},
+				{"op": "add", "path": "/spec/workers/template/spec/containers/0/env", "value": []interface{}{map[string]interface{}{"name": "GOMAXPROCS", "value": "4"}}},
+				{"op": "add", "path": "/spec/workers/template/spec/initContainers/0/env", "value": []interface{}{map[string]interface{}{"name": "GOMAXPROCS", "value": "3"}}},
+			},
+		},
+		{
+			desc: "update leaving pod spec unchanged",
+			kind: metav1.GroupVersionKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "Rollout"},
+			oldObject: `{
+				"apiVersion": "argoproj.io/v1alpha1",
+				"kind": "Rollout",
+				"metadata": {"name": "test", "namespace": "default"},
+				"spec": {"template": {"spec": {"containers": [{"name": "app", "resources": {"limits": {"cpu": "2"}}}]}}}
+			}`,
+			object: `{
+				"apiVersion": "argoproj.io/v1alpha1",
+				"kind": "Rollout",
+				"metadata": {"name": "test", "namespace": "default", "annotations": {"rollout.argoproj.io/revision": "2"}},
+				"spec": {"template": {"spec": {"containers": [{"name": "app", "resources": {"limits": {"cpu": "2"}}}]}}}
+			}`,
+			allowed: true,
+		},
+		{
+			desc: "update changing one of several pod specs",
+			kind: metav1.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Runner"},
+			oldObject: `{
+				"apiVersion": "example.com/v1",
+				"kind": "Runner",
+				"metadata": {"name": "test", "namespace": "default"},
+				"spec": {
+					"leader": {"containers": [{"name": "leader", "resources": {"limits": {"cpu": "1"}}}]},
+					"workers": {"template": {"spec": {"containers": [{"name": "worker", "resources": {"limits": {"cpu": "4"}}}]}}}
+				}
+			}`,
+			object: `{
+				"apiVersion": "example.com/v1",
+				"kind": "Runner",
+				"metadata": {"name": "test", "namespace": "default"},
+				"spec": {
+					"leader": {"containers": [{"name": "leader", "resources": {"limits": {"cpu": "2"}}}]},
+					"workers": {"template": {"spec": {"containers": [{"name": "worker", "resources": {"limits": {"cpu": "4"}}}]}}}
+				}
+			}`,
+			allowed: true,
+			expectedPatch: []map[string]interface{}{
+				{"op": "add", "path": "/spec/leader/containers/0/env", "value": []interface{}{map[string]interface{}{"name": "GOMAXPROCS", "value": "2"}}},
+			},
+		},
+		{
+			desc:    "missing path",
+			kind:    metav1.GroupVersionKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "Rollout"},
+			object:  `{"apiVersion": "argoproj.io/v1alpha1", "kind": "Rollout", "metadata": {"name": "test"}, "spec": {}}`,
+			allowed: true,
+		},
+		{
+			desc:    "unknown kind",
+			kind:    metav1.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Unknown"},
+			object:  `{"apiVersion": "example.com/v1", "kind": "Unknown", "metadata": {"name": "test"}}`,
+			allowed: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			review := v1.AdmissionReview{
+				Request: &v1.AdmissionRequest{
+					Kind:      tc.kind,
+					Resource:  metav1.GroupVersionResource{Group: tc.kind.Group, Version: tc.kind.Version, Resource: "things"},
+					Operation: v1.Create,
+					Namespace: "default",
+					Name:      "test",
+					Object:    runtime.RawExtension{Raw: []byte(tc.object)},
+				},
+			}
+			if tc.oldObject != "" {
+				review.Request.Operation = v1.Update
+				review.Request.OldObject = runtime.RawExtension{Raw: []byte(tc.oldObject)}
+			}
+
+			res := c.admit(context.Background(), review)
+			if res.Allowed != tc.allowed {
+				t.Fatalf("expected %v, got %v: %v", tc.allowed, res.Allowed, res.Result)
+			}
+			if !tc.allowed {
+				return
+			}
+
+			var patch []map[string]interface{}
+			if res.Patch != nil {
+				if err := json.Unmarshal(res.Patch, &patch); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if diff := cmp.Diff(tc.expectedPatch, patch); diff != "" {
+				t.Errorf("unexpected patch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
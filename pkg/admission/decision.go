@@ -0,0 +1,149 @@
+package admission
+
+import (
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// decisionsAnnotationKey holds the decisions taken for the containers of a
+// pod, so that tools can tell from the pod where its variables came from.
+var decisionsAnnotationKey = "gomaxprocs-injector/decisions"
+
+// admittedBefore reports whether a previous invocation of the webhook admitted
+// the pod, such as before a reinvocation, in which case the pod carries the
+// decisions taken for it. Decisions inherited from a pod template carry the
+// path of the template and do not count.
+func admittedBefore(pod *corev1.Pod) bool {
+	value, ok := pod.Annotations[decisionsAnnotationKey]
+	if !ok {
+		return false
+	}
+
+	var decisions []decision
+	if err := json.Unmarshal([]byte(value), &decisions); err != nil {
+		return false
+	}
+	for _, d := range decisions {
+		if d.Path == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// decisionsAuditAnnotationKey is the key of the audit annotation that lists
+// the decisions taken for an object. The API server prefixes it with the name
+// of the webhook.
+const decisionsAuditAnnotationKey = "decisions"
+
+// patchAuditAnnotationKey is the key of the audit annotation that holds the
+// JSONPatch that was not applied in report-only mode.
+const patchAuditAnnotationKey = "patch"
+
+// decisionAction is what the webhook did with a variable of a container.
+type decisionAction string
+
+const (
+	// decisionInjected means the webhook set the variable.
+	decisionInjected decisionAction = "injected"
+	// decisionSkipped means the webhook left the variable unset.
+	decisionSkipped decisionAction = "skipped"
+	// decisionKept means the variable was set by the user and kept.
+	decisionKept decisionAction = "kept"
+	// decisionOverridden means the variable was set by the user and replaced.
+	decisionOverridden decisionAction = "overridden"
+)
+
+// decisionReason explains a decision in a form suitable for matching.
+type decisionReason string
+
+const (
+	reasonCPULimit            decisionReason = "cpu-limit"
+	reasonCPURequest          decisionReason = "cpu-request"
+	reasonPinned              decisionReason = "annotation"
+	reasonMemoryLimit         decisionReason = "memory-limit"
+	reasonNoCPULimit          decisionReason = "no-cpu-limit"
+	reasonNoMemoryLimit       decisionReason = "no-memory-limit"
+	reasonContainerDisabled   decisionReason = "container-disabled"
+	reasonInitContainerPolicy decisionReason = "init-container-policy"
+	reasonUserSet             decisionReason = "user-set"
+	reasonReference           decisionReason = "reference"
+	reasonWithinLimit         decisionReason = "within-limit"
+	reasonNotPositiveInteger  decisionReason = "not-positive-integer"
+	reasonExceedsLimit        decisionReason = "exceeds-limit"
+)
+
+// decision records what the webhook did with a variable of a container, or
+// with the container as a whole if Variable is empty.
+type decision struct {
+	// Path is the path of the pod spec in a workload or custom resource, if
+	// any.
+	Path      string         `json:"path,omitempty"`
+	Container string         `json:"container"`
+	Variable  string         `json:"variable,omitempty"`
+	Action    decisionAction `json:"action"`
+	// Value is the value the variable has after the mutation.
+	Value string `json:"value,omitempty"`
+	// Previous is the value set by the user that was overridden.
+	Previous string `json:"previous,omitempty"`
+	// CPULimit is the CPU limit that applies to the container, if any.
+	CPULimit string `json:"cpuLimit,omitempty"`
+	// Strategy is the strategy GOMAXPROCS was computed with, if it was
+	// computed.
+	Strategy string `json:"strategy,omitempty"`
+	// ConfigVersion is the version of the Config the decision was taken
+	// under.
+	ConfigVersion string         `json:"configVersion,omitempty"`
+	Reason        decisionReason `json:"reason"`
+}
+
+// String describes the decision for admission warnings.
+func (d decision) String() string {
+	subject := fmt.Sprintf("container %q", d.Container)
+	if d.Path != "" {
+		subject = fmt.Sprintf("pod spec at %s, %s", d.Path, subject)
+	}
+
+	switch {
+	case d.Variable == "":
+		return fmt.Sprintf("%s: %s (%s)", subject, d.Action, d.Reason)
+	case d.Action == decisionInjected:
+		return fmt.Sprintf("%s: %s set to %s (%s)", subject, d.Variable, d.Value, d.Reason)
+	case d.Action == decisionSkipped:
+		return fmt.Sprintf("%s: %s not set (%s)", subject, d.Variable, d.Reason)
+	case d.Action == decisionKept:
+		return fmt.Sprintf("%s: %s set by the user kept (%s)", subject, d.Variable, d.Reason)
+	default:
+		return fmt.Sprintf("%s: %s %q set by the user overridden to %s (%s)", subject, d.Variable, d.Previous, d.Value, d.Reason)
+	}
+}
+
+// decide records a decision and, if enabled or in report-only mode, reports
+// it as a warning. Overrides are always reported with a warning of their own.
+func (m *mutation) decide(d decision) {
+	d.Path = m.path
+	d.ConfigVersion = m.config.Version
+	m.decisions = append(m.decisions, d)
+	m.decisionEvent(d)
+	if (m.config.DecisionWarnings || m.config.Mode == ModeReportOnly) && d.Action != decisionOverridden {
+		m.warn(d.String())
+	}
+}
+
+// auditAnnotations returns the audit annotations that list the decisions, or
+// nil if there are none.
+func auditAnnotations(decisions []decision) map[string]string {
+	if len(decisions) == 0 {
+		return nil
+	}
+
+	value, err := json.Marshal(decisions)
+	if err != nil {
+		klog.ErrorS(err, "Failed to marshal decisions")
+		return nil
+	}
+	return map[string]string{decisionsAuditAnnotationKey: string(value)}
+}
@@ -0,0 +1,186 @@
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/admission/v1"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAdmitDecisions(t *testing.T) {
+	withGOMAXPROCS := func(container corev1.Container, value string) corev1.Container {
+		container.Env = append(container.Env, corev1.EnvVar{Name: "GOMAXPROCS", Value: value})
+		return container
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-pod",
+			Namespace: "default",
+		},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{
+				namedContainer("init", containerWithCPULimit("1")),
+			},
+			Containers: []corev1.Container{
+				namedContainer("limited", containerWithCPULimit("2")),
+				namedContainer("unlimited", corev1.Container{}),
+				namedContainer("user", withGOMAXPROCS(containerWithCPULimit("2"), "16")),
+			},
+		},
+	}
+
+	testCases := []struct {
+		desc             string
+		enforce          bool
+		decisionWarnings bool
+
+		expectedDecisions []decision
+		expectedWarnings  int
+	}{
+		{
+			desc: "audit annotation only",
+			expectedDecisions: []decision{
+				{Container: "init", Action: decisionSkipped, ConfigVersion: "test", Reason: reasonInitContainerPolicy},
+				{Container: "limited", Variable: "GOMAXPROCS", Action: decisionInjected, Value: "2", CPULimit: "2", Strategy: "floor", ConfigVersion: "test", Reason: reasonCPULimit},
+				{Container: "unlimited", Variable: "GOMAXPROCS", Action: decisionSkipped, ConfigVersion: "test", Reason: reasonNoCPULimit},
+				{Container: "user", Variable: "GOMAXPROCS", Action: decisionKept, Value: "16", CPULimit: "2", ConfigVersion: "test", Reason: reasonUserSet},
+			},
+		},
+		{
+			desc:             "with warnings",
+			decisionWarnings: true,
+			expectedDecisions: []decision{
+				{Container: "init", Action: decisionSkipped, ConfigVersion: "test", Reason: reasonInitContainerPolicy},
+				{Container: "limited", Variable: "GOMAXPROCS", Action: decisionInjected, Value: "2", CPULimit: "2", Strategy: "floor", ConfigVersion: "test", Reason: reasonCPULimit},
+				{Container: "unlimited", Variable: "GOMAXPROCS", Action: decisionSkipped, ConfigVersion: "test", Reason: reasonNoCPULimit},
+				{Container: "user", Variable: "GOMAXPROCS", Action: decisionKept, Value: "16", CPULimit: "2", ConfigVersion: "test", Reason: reasonUserSet},
+			},
+			expectedWarnings: 4,
+		},
+		{
+			desc:             "overridden",
+			enforce:          true,
+			decisionWarnings: true,
+			expectedDecisions: []decision{
+				{Container: "init", Action: decisionSkipped, ConfigVersion: "test", Reason: reasonInitContainerPolicy},
+				{Container: "limited", Variable: "GOMAXPROCS", Action: decisionInjected, Value: "2", CPULimit: "2", Strategy: "floor", ConfigVersion: "test", Reason: reasonCPULimit},
+				{Container: "unlimited", Variable: "GOMAXPROCS", Action: decisionSkipped, ConfigVersion: "test", Reason: reasonNoCPULimit},
+				{Container: "user", Variable: "GOMAXPROCS", Action: decisionOverridden, Value: "2", Previous: "16", CPULimit: "2", Strategy: "floor", ConfigVersion: "test", Reason: reasonExceedsLimit},
+			},
+			// The override is reported once, with its own warning.
+			expectedWarnings: 4,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			config := DefaultConfig()
+			config.Version = "test"
+			config.InitContainers = InitContainerPolicySkip
+			config.Enforce = tc.enforce
+			config.DecisionWarnings = tc.decisionWarnings
+			c := NewController(config)
+
+			review := v1.AdmissionReview{
+				Request: &v1.AdmissionRequest{
+					Resource: metav1.GroupVersionResource{
+						Group:    "",
+						Version:  "v1",
+						Resource: "pods",
+					},
+					Object: newPodObjectFromPod(t, pod),
+				},
+			}
+			res := c.admit(context.Background(), review)
+			if !res.Allowed {
+				t.Fatalf("expected allowed, got %v", res.Result)
+			}
+
+			// The pod is annotated with the same decisions as the request.
+			checkPatch(t, review.Request.Object.Raw, res.Patch, func(expectedPod *corev1.Pod) {
+				expectedPod.Annotations = map[string]string{decisionsAnnotationKey: res.AuditAnnotations["decisions"]}
+				if tc.enforce {
+					expectedPod.Annotations[overriddenAnnotationKey] = `{"user":{"value":"16","reason":"16 exceeds the maximum of 2 for the cpu resource limit"}}`
+					expectedPod.Spec.Containers[2].Env[0].Value = "2"
+				}
+				expectedPod.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "GOMAXPROCS", Value: "2"}}
+			})
+
+			var decisions []decision
+			if err := json.Unmarshal([]byte(res.AuditAnnotations["decisions"]), &decisions); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decisions, tc.expectedDecisions) {
+				t.Errorf("expected decisions %+v, got %+v", tc.expectedDecisions, decisions)
+			}
+			if len(res.Warnings) != tc.expectedWarnings {
+				t.Errorf("expected %d warnings, got %v", tc.expectedWarnings, res.Warnings)
+			}
+		})
+	}
+}
+
+func TestAdmitDecisionsV1beta1(t *testing.T) {
+	c := NewController(DefaultConfig())
+
+	res := c.admitV1beta1(context.Background(), v1beta1.AdmissionReview{
+		Request: convertAdmissionRequestToV1beta1(&v1.AdmissionRequest{
+			Resource: metav1.GroupVersionResource{
+				Group:    "",
+				Version:  "v1",
+				Resource: "pods",
+			},
+			Object: newTestPodObject(t, "test-pod", namedContainer("app", containerWithCPULimit("2"))),
+		}),
+	})
+	expected := `[{"container":"app","variable":"GOMAXPROCS","action":"injected","value":"2","cpuLimit":"2","strategy":"floor","reason":"cpu-limit"}]`
+	if res.AuditAnnotations["decisions"] != expected {
+		t.Errorf("expected audit annotation %s, got %s", expected, res.AuditAnnotations["decisions"])
+	}
+}
+
+func TestAdmittedBefore(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		annotations map[string]string
+		expected    bool
+	}{
+		{
+			desc: "new pod",
+		},
+		{
+			desc: "pod with decisions of its own",
+			annotations: map[string]string{
+				decisionsAnnotationKey: `[{"container":"app","variable":"GOMAXPROCS","action":"injected","value":"2","reason":"cpu-limit"}]`,
+			},
+			expected: true,
+		},
+		{
+			desc: "pod with decisions inherited from a template",
+			annotations: map[string]string{
+				injectedAnnotationKey:  `{"app":{"GOMAXPROCS":"2"}}`,
+				decisionsAnnotationKey: `[{"path":"spec.template.spec","container":"app","variable":"GOMAXPROCS","action":"injected","value":"2","reason":"cpu-limit"}]`,
+			},
+		},
+		{
+			desc: "invalid annotation",
+			annotations: map[string]string{
+				decisionsAnnotationKey: `invalid`,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+			if got := admittedBefore(pod); got != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
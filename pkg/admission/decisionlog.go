@@ -0,0 +1,55 @@
+package admission
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gjkim42/gomaxprocs-injector/pkg/decisionlog"
+	v1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// decisionLogEntry describes request and the response of webhook to it for
+// the decision log. The decisions and the JSONPatch are taken from the
+// response, which holds them in its audit annotations in report-only mode.
+func decisionLogEntry(webhook string, request *v1.AdmissionRequest, response *v1.AdmissionResponse) decisionlog.Entry {
+	entry := decisionlog.Entry{
+		Time:        time.Now(),
+		Webhook:     webhook,
+		UID:         string(request.UID),
+		User:        request.UserInfo.Username,
+		Operation:   string(request.Operation),
+		Resource:    request.Resource.String(),
+		SubResource: request.SubResource,
+		Namespace:   request.Namespace,
+		Name:        request.Name,
+		DryRun:      request.DryRun != nil && *request.DryRun,
+		Allowed:     response.Allowed,
+		Patched:     response.Patch != nil,
+		Warnings:    response.Warnings,
+	}
+
+	// Pods being created usually have no name yet, only a generateName.
+	var object struct {
+		Metadata metav1.ObjectMeta `json:"metadata"`
+	}
+	if err := json.Unmarshal(request.Object.Raw, &object); err == nil {
+		if entry.Name == "" {
+			entry.Name = object.Metadata.Name
+		}
+		entry.GenerateName = object.Metadata.GenerateName
+	}
+
+	if response.Result != nil && !response.Allowed {
+		entry.Message = response.Result.Message
+	}
+	if decisions, ok := response.AuditAnnotations[decisionsAuditAnnotationKey]; ok {
+		entry.Decisions = json.RawMessage(decisions)
+	}
+	if response.Patch != nil {
+		entry.Patch = json.RawMessage(response.Patch)
+	} else if patch, ok := response.AuditAnnotations[patchAuditAnnotationKey]; ok {
+		entry.Patch = json.RawMessage(patch)
+	}
+	return entry
+}
@@ -0,0 +1,120 @@
+package admission
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gjkim42/gomaxprocs-injector/pkg/decisionlog"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	v1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestServeHTTPDecisionLog(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "test-"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				containerWithCPULimit("2"),
+			},
+		},
+	}
+	decisions := `[{"container":"","variable":"GOMAXPROCS","action":"injected","value":"2","cpuLimit":"2","strategy":"floor","reason":"cpu-limit"}]`
+
+	testCases := []struct {
+		desc     string
+		mode     Mode
+		expected decisionlog.Entry
+	}{
+		{
+			desc: "mutate",
+			mode: ModeMutate,
+			expected: decisionlog.Entry{
+				Webhook:      mutatingWebhook,
+				UID:          "1",
+				User:         "system:serviceaccount:kube-system:replicaset-controller",
+				Operation:    "CREATE",
+				Resource:     "/v1, Resource=pods",
+				Namespace:    "default",
+				GenerateName: "test-",
+				Allowed:      true,
+				Decisions:    json.RawMessage(decisions),
+				Patched:      true,
+			},
+		},
+		{
+			desc: "report-only",
+			mode: ModeReportOnly,
+			expected: decisionlog.Entry{
+				Webhook:      mutatingWebhook,
+				UID:          "1",
+				User:         "system:serviceaccount:kube-system:replicaset-controller",
+				Operation:    "CREATE",
+				Resource:     "/v1, Resource=pods",
+				Namespace:    "default",
+				GenerateName: "test-",
+				Allowed:      true,
+				Decisions:    json.RawMessage(decisions),
+				Warnings: []string{
+					"report-only mode: 2 changes to the pod were not applied",
+					`container "": GOMAXPROCS set to 2 (cpu-limit)`,
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			config := DefaultConfig()
+			config.Mode = tc.mode
+			var buf bytes.Buffer
+			recent := decisionlog.NewRing(10)
+			c := NewController(config,
+				WithDecisionLog(decisionlog.New(&buf, decisionlog.Sampling{})),
+				WithRecentDecisions(recent),
+			)
+
+			c.ServeHTTP(httptest.NewRecorder(), newReviewRequest(t, &v1.AdmissionReview{
+				TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+				Request: &v1.AdmissionRequest{
+					UID:       "1",
+					Resource:  podResource,
+					Operation: v1.Create,
+					Namespace: "default",
+					UserInfo:  authenticationv1.UserInfo{Username: "system:serviceaccount:kube-system:replicaset-controller"},
+					Object:    newPodObjectFromPod(t, pod),
+				},
+			}))
+
+			var entry decisionlog.Entry
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("expected a single JSON line, got %q: %v", buf.String(), err)
+			}
+			if entry.Time.IsZero() {
+				t.Errorf("expected the time of the request")
+			}
+			// The patch itself is checked by the tests of admit.
+			var patch []map[string]interface{}
+			if err := json.Unmarshal(entry.Patch, &patch); err != nil || len(patch) != 2 {
+				t.Errorf("expected a patch of 2 operations, got %s", entry.Patch)
+			}
+			if diff := cmp.Diff(tc.expected, entry, cmpopts.IgnoreFields(decisionlog.Entry{}, "Time", "Patch")); diff != "" {
+				t.Errorf("unexpected entry (-want +got):\n%s", diff)
+			}
+
+			// The recent decisions hold the same entry.
+			recentEntries := recent.List(decisionlog.Filter{Namespace: "default", NamePrefix: "test-"})
+			if len(recentEntries) != 1 {
+				t.Fatalf("expected 1 recent entry, got %d", len(recentEntries))
+			}
+			if diff := cmp.Diff(tc.expected, recentEntries[0], cmpopts.IgnoreFields(decisionlog.Entry{}, "Time", "Patch")); diff != "" {
+				t.Errorf("unexpected recent entry (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
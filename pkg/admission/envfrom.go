@@ -0,0 +1,156 @@
+package admission
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// EnvFromErrorPolicy decides what happens to a variable when the ConfigMaps
+// or Secrets referenced by envFrom cannot be looked up.
+type EnvFromErrorPolicy string
+
+const (
+	// EnvFromErrorPolicySkip treats the variable as set by the user.
+	EnvFromErrorPolicySkip EnvFromErrorPolicy = "skip"
+	// EnvFromErrorPolicyInject injects the variable anyway.
+	EnvFromErrorPolicyInject EnvFromErrorPolicy = "inject"
+)
+
+// Validate checks that the policy is known.
+func (p EnvFromErrorPolicy) Validate() error {
+	switch p {
+	case EnvFromErrorPolicySkip, EnvFromErrorPolicyInject:
+		return nil
+	default:
+		return fmt.Errorf("unknown envFrom error policy %q, must be %q or %q", p, EnvFromErrorPolicySkip, EnvFromErrorPolicyInject)
+	}
+}
+
+// envFromResolver looks up whether the envFrom sources of a container provide
+// a variable.
+type envFromResolver struct {
+	configMapLister corelisters.ConfigMapLister
+	secretLister    corelisters.SecretLister
+}
+
+// provides reports whether any envFrom source of the container provides the
+// variable name. Optional sources that do not exist provide nothing.
+func (r *envFromResolver) provides(namespace string, container *corev1.Container, name string) (bool, error) {
+	_, found, err := r.lookup(namespace, container, name)
+	return found, err
+}
+
+// lookup returns the value the envFrom sources of the container provide for
+// the variable name. Like the kubelet, later sources take precedence.
+func (r *envFromResolver) lookup(namespace string, container *corev1.Container, name string) (string, bool, error) {
+	var value string
+	var found bool
+	for _, source := range container.EnvFrom {
+		switch {
+		case source.ConfigMapRef != nil:
+			configMap, err := r.configMapLister.ConfigMaps(namespace).Get(source.ConfigMapRef.Name)
+			if apierrors.IsNotFound(err) && isOptional(source.ConfigMapRef.Optional) {
+				continue
+			}
+			if err != nil {
+				return "", false, fmt.Errorf("failed to get configmap %s/%s: %w", namespace, source.ConfigMapRef.Name, err)
+			}
+			for key, data := range configMap.Data {
+				if source.Prefix+key == name {
+					value, found = data, true
+				}
+			}
+			for key, data := range configMap.BinaryData {
+				if source.Prefix+key == name {
+					value, found = string(data), true
+				}
+			}
+		case source.SecretRef != nil:
+			secret, err := r.secretLister.Secrets(namespace).Get(source.SecretRef.Name)
+			if apierrors.IsNotFound(err) && isOptional(source.SecretRef.Optional) {
+				continue
+			}
+			if err != nil {
+				return "", false, fmt.Errorf("failed to get secret %s/%s: %w", namespace, source.SecretRef.Name, err)
+			}
+			for key, data := range secret.Data {
+				if source.Prefix+key == name {
+					value, found = string(data), true
+				}
+			}
+		}
+	}
+
+	return value, found, nil
+}
+
+func isOptional(optional *bool) bool {
+	return optional != nil && *optional
+}
+
+// resolvesEnvFrom reports whether the envFrom sources of the containers of
+// the pod are looked up.
+func (c *Controller) resolvesEnvFrom(m *mutation) bool {
+	return c.envFrom != nil && m.config.ResolveEnvFrom
+}
+
+// isUserSet reports whether the container already sets the variable name,
+// either directly in env, including through valueFrom, or through envFrom
+// when resolving envFrom is enabled.
+func (c *Controller) isUserSet(m *mutation, container *corev1.Container, name string) bool {
+	if hasEnv(container, name) {
+		return true
+	}
+
+	if !c.resolvesEnvFrom(m) || len(container.EnvFrom) == 0 {
+		return false
+	}
+
+	provided, err := c.envFrom.provides(m.pod.Namespace, container, name)
+	if err != nil {
+		klog.ErrorS(err, "Failed to resolve envFrom", "container", container.Name, "env", name, "policy", m.config.EnvFromErrorPolicy)
+		return m.config.EnvFromErrorPolicy != EnvFromErrorPolicyInject
+	}
+	return provided
+}
+
+// TrimEnvSourceValues is an informer transform that drops the values of
+// ConfigMaps and Secrets, as only their keys are needed to resolve envFrom.
+// The values of keys that can provide a variable the webhook manages, with or
+// without a prefix, are kept for validation.
+func TrimEnvSourceValues(obj interface{}) (interface{}, error) {
+	switch o := obj.(type) {
+	case *corev1.ConfigMap:
+		o.ManagedFields = nil
+		for key := range o.Data {
+			if !isManagedEnvSuffix(key) {
+				o.Data[key] = ""
+			}
+		}
+		for key := range o.BinaryData {
+			if !isManagedEnvSuffix(key) {
+				o.BinaryData[key] = nil
+			}
+		}
+	case *corev1.Secret:
+		o.ManagedFields = nil
+		for key := range o.Data {
+			if !isManagedEnvSuffix(key) {
+				o.Data[key] = nil
+			}
+		}
+		o.StringData = nil
+	}
+	return obj, nil
+}
+
+// isManagedEnvSuffix reports whether key, with some prefix, is the name of a
+// variable the webhook manages.
+func isManagedEnvSuffix(key string) bool {
+	return key != "" && (strings.HasSuffix(gomaxprocsEnvName, key) || strings.HasSuffix(gomemlimitEnvName, key))
+}
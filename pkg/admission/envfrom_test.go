@@ -0,0 +1,150 @@
+package admission
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestAdmitEnvFrom(t *testing.T) {
+	optional := true
+	configMaps := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	secrets := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, obj := range []interface{}{
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "with-gomaxprocs", Namespace: "default"},
+			Data:       map[string]string{"GOMAXPROCS": "4"},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "with-prefixed-gomaxprocs", Namespace: "default"},
+			Data:       map[string]string{"MAXPROCS": "4"},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "without-gomaxprocs", Namespace: "default"},
+			Data:       map[string]string{"FOO": "bar"},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "with-gomaxprocs", Namespace: "other"},
+			Data:       map[string]string{"FOO": "bar"},
+		},
+	} {
+		if err := configMaps.Add(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := secrets.Add(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "with-gomaxprocs", Namespace: "default"},
+		Data:       map[string][]byte{"GOMAXPROCS": []byte("4")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	withEnvFrom := func(container corev1.Container, sources ...corev1.EnvFromSource) corev1.Container {
+		container.EnvFrom = sources
+		return container
+	}
+	configMapRef := func(name, prefix string, optional *bool) corev1.EnvFromSource {
+		return corev1.EnvFromSource{
+			Prefix: prefix,
+			ConfigMapRef: &corev1.ConfigMapEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: name},
+				Optional:             optional,
+			},
+		}
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-pod",
+			Namespace: "default",
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				withEnvFrom(containerWithCPULimit("2"), configMapRef("with-gomaxprocs", "", nil)),
+				withEnvFrom(containerWithCPULimit("2"), configMapRef("with-prefixed-gomaxprocs", "GO", nil)),
+				withEnvFrom(containerWithCPULimit("2"), configMapRef("without-gomaxprocs", "", nil)),
+				withEnvFrom(containerWithCPULimit("2"), corev1.EnvFromSource{
+					SecretRef: &corev1.SecretEnvSource{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "with-gomaxprocs"},
+					},
+				}),
+				withEnvFrom(containerWithCPULimit("2"), configMapRef("missing", "", &optional)),
+				withEnvFrom(containerWithCPULimit("2"), configMapRef("missing", "", nil)),
+				{
+					Name: "container-with-GOMAXPROCS-from-field",
+					Env: []corev1.EnvVar{
+						{
+							Name: "GOMAXPROCS",
+							ValueFrom: &corev1.EnvVarSource{
+								ResourceFieldRef: &corev1.ResourceFieldSelector{Resource: "limits.cpu"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	testCases := []struct {
+		desc        string
+		errorPolicy EnvFromErrorPolicy
+
+		expectedContainersGOMAXPROCS []int
+	}{
+		{
+			desc:        "skip on error",
+			errorPolicy: EnvFromErrorPolicySkip,
+			expectedContainersGOMAXPROCS: []int{
+				0, // with-gomaxprocs
+				0, // with-prefixed-gomaxprocs
+				2, // without-gomaxprocs
+				0, // secret with-gomaxprocs
+				2, // optional missing
+				0, // missing
+				0, // valueFrom
+			},
+		},
+		{
+			desc:        "inject on error",
+			errorPolicy: EnvFromErrorPolicyInject,
+			expectedContainersGOMAXPROCS: []int{
+				0, // with-gomaxprocs
+				0, // with-prefixed-gomaxprocs
+				2, // without-gomaxprocs
+				0, // secret with-gomaxprocs
+				2, // optional missing
+				2, // missing
+				0, // valueFrom
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			config := DefaultConfig()
+			config.ResolveEnvFrom = true
+			config.EnvFromErrorPolicy = tc.errorPolicy
+			c := NewController(config, WithEnvFromListers(corelisters.NewConfigMapLister(configMaps), corelisters.NewSecretLister(secrets)))
+
+			review := v1.AdmissionReview{
+				Request: &v1.AdmissionRequest{
+					Resource: metav1.GroupVersionResource{
+						Group:    "",
+						Version:  "v1",
+						Resource: "pods",
+					},
+					Object: newPodObjectFromPod(t, pod),
+				},
+			}
+			res := c.admit(context.Background(), review)
+			if !res.Allowed {
+				t.Fatalf("expected allowed, got %v", res.Result)
+			}
+			checkGOMAXPROCS(t, review.Request.Object.Raw, res.Patch, nil, tc.expectedContainersGOMAXPROCS)
+		})
+	}
+}
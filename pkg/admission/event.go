@@ -0,0 +1,122 @@
+package admission
+
+import (
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// noCPULimitEventsAnnotationKey enables Events for containers left without
+// GOMAXPROCS because they have no CPU limit in a namespace.
+var noCPULimitEventsAnnotationKey = "gomaxprocs-injector/no-cpu-limit-events"
+
+// Reasons of the Events emitted for pods.
+const (
+	eventReasonOverridden        = "GOMAXPROCSOverridden"
+	eventReasonInvalidAnnotation = "InvalidAnnotation"
+	eventReasonNoCPULimit        = "GOMAXPROCSNotSet"
+)
+
+// event is an Event to be emitted about a pod, or about its namespace.
+type event struct {
+	// onNamespace tells that the event is about the namespace rather than
+	// the pod.
+	onNamespace bool
+	eventType   string
+	reason      string
+	message     string
+}
+
+// annotationError is an invalid annotation on a pod.
+type annotationError struct {
+	err error
+}
+
+func (e annotationError) Error() string {
+	return e.err.Error()
+}
+
+func (e annotationError) Unwrap() error {
+	return e.err
+}
+
+// decisionEvent records an Event for d if it is worth telling the author of
+// the pod about. Overrides are not reported in report-only mode, as they are
+// not applied.
+func (m *mutation) decisionEvent(d decision) {
+	switch {
+	case d.Action == decisionOverridden && m.config.Mode != ModeReportOnly:
+		m.events = append(m.events, event{eventType: corev1.EventTypeWarning, reason: eventReasonOverridden, message: d.String()})
+	case d.Action == decisionSkipped && d.Reason == reasonNoCPULimit && d.Variable == gomaxprocsEnvName && noCPULimitEventsEnabled(m.namespace):
+		m.events = append(m.events, event{eventType: corev1.EventTypeNormal, reason: eventReasonNoCPULimit, message: d.String()})
+	}
+}
+
+// noCPULimitEventsEnabled evaluates the no-cpu-limit-events annotation of the
+// namespace.
+func noCPULimitEventsEnabled(namespace *corev1.Namespace) bool {
+	return namespace != nil && namespace.Annotations[noCPULimitEventsAnnotationKey] == injectEnabledValue
+}
+
+// invalidAnnotationEvents returns the Events for err if it is caused by an
+// invalid annotation on the pod.
+func invalidAnnotationEvents(err error) []event {
+	var annotationErr annotationError
+	if !errors.As(err, &annotationErr) {
+		return nil
+	}
+	return []event{{eventType: corev1.EventTypeWarning, reason: eventReasonInvalidAnnotation, message: annotationErr.Error()}}
+}
+
+// emitEvents emits events about pod. Events about the pod go on its
+// controller, as the pod may not exist yet, or on its namespace if it has
+// none. The recorder rate-limits and aggregates the Events of each object.
+func (c *Controller) emitEvents(pod *corev1.Pod, namespace *corev1.Namespace, events []event) {
+	if c.recorder == nil {
+		return
+	}
+
+	for _, e := range events {
+		ref, message := namespaceReference(pod.Namespace, namespace), e.message
+		if !e.onNamespace {
+			if owner := metav1.GetControllerOf(pod); owner != nil {
+				ref = &corev1.ObjectReference{
+					APIVersion: owner.APIVersion,
+					Kind:       owner.Kind,
+					Namespace:  pod.Namespace,
+					Name:       owner.Name,
+					UID:        owner.UID,
+				}
+			} else {
+				message = fmt.Sprintf("pod %s: %s", podName(pod), message)
+			}
+		}
+		c.recorder.Event(ref, e.eventType, e.reason, message)
+	}
+}
+
+// namespaceReference refers to the namespace named name. The Events about the
+// namespace are recorded in the namespace itself, where its users look.
+func namespaceReference(name string, namespace *corev1.Namespace) *corev1.ObjectReference {
+	ref := &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Namespace",
+		Namespace:  name,
+		Name:       name,
+	}
+	if namespace != nil {
+		ref.UID = namespace.UID
+	}
+	return ref
+}
+
+// podName returns the name of the pod, or its generateName followed by an
+// asterisk if it has no name yet.
+func podName(pod *corev1.Pod) string {
+	if pod.Name == "" && pod.GenerateName != "" {
+		return pod.GenerateName + "*"
+	}
+	return pod.Name
+}
@@ -0,0 +1,219 @@
+package admission
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// recordedEvent is an Event recorded by testRecorder.
+type recordedEvent struct {
+	Object    corev1.ObjectReference
+	EventType string
+	Reason    string
+	Message   string
+}
+
+// testRecorder records the Events emitted about object references.
+type testRecorder struct {
+	events []recordedEvent
+}
+
+func (r *testRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	r.events = append(r.events, recordedEvent{Object: *object.(*corev1.ObjectReference), EventType: eventtype, Reason: reason, Message: message})
+}
+
+func (r *testRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.Event(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (r *testRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.Eventf(object, eventtype, reason, messageFmt, args...)
+}
+
+func TestAdmitEvents(t *testing.T) {
+	namespaces := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, namespace := range []*corev1.Namespace{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "default", UID: "default-uid"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "flagged",
+				UID:         "flagged-uid",
+				Annotations: map[string]string{noCPULimitEventsAnnotationKey: "enabled"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "invalid",
+				UID:         "invalid-uid",
+				Annotations: map[string]string{minAnnotationKey: "zero"},
+			},
+		},
+	} {
+		if err := namespaces.Add(namespace); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	controller := true
+	owner := metav1.OwnerReference{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "app-5d4f8b9c7", UID: "rs-uid", Controller: &controller}
+	replicaSet := corev1.ObjectReference{APIVersion: "apps/v1", Kind: "ReplicaSet", Namespace: "default", Name: "app-5d4f8b9c7", UID: "rs-uid"}
+	userSet := corev1.Container{
+		Name: "app",
+		Env:  []corev1.EnvVar{{Name: gomaxprocsEnvName, Value: "64"}},
+		Resources: corev1.ResourceRequirements{
+			Limits: containerWithCPULimit("2").Resources.Limits,
+		},
+	}
+
+	testCases := []struct {
+		desc        string
+		namespace   string
+		annotations map[string]string
+		owned       bool
+		container   corev1.Container
+		mode        Mode
+		dryRun      bool
+
+		allowed  bool
+		expected []recordedEvent
+	}{
+		{
+			desc:      "override on the owner",
+			namespace: "default",
+			owned:     true,
+			container: userSet,
+			allowed:   true,
+			expected: []recordedEvent{{
+				Object:    replicaSet,
+				EventType: corev1.EventTypeWarning,
+				Reason:    eventReasonOverridden,
+				Message:   `container "app": GOMAXPROCS "64" set by the user overridden to 2 (exceeds-limit)`,
+			}},
+		},
+		{
+			desc:      "override in a dry run",
+			namespace: "default",
+			owned:     true,
+			container: userSet,
+			dryRun:    true,
+			allowed:   true,
+		},
+		{
+			desc:      "override in report-only mode",
+			namespace: "default",
+			owned:     true,
+			container: userSet,
+			mode:      ModeReportOnly,
+			allowed:   true,
+		},
+		{
+			desc:      "no cpu limit in a flagged namespace, on the namespace",
+			namespace: "flagged",
+			container: corev1.Container{Name: "app"},
+			allowed:   true,
+			expected: []recordedEvent{{
+				Object:    corev1.ObjectReference{APIVersion: "v1", Kind: "Namespace", Namespace: "flagged", Name: "flagged", UID: "flagged-uid"},
+				EventType: corev1.EventTypeNormal,
+				Reason:    eventReasonNoCPULimit,
+				Message:   `pod app-*: container "app": GOMAXPROCS not set (no-cpu-limit)`,
+			}},
+		},
+		{
+			desc:      "no cpu limit in a namespace that is not flagged",
+			namespace: "default",
+			container: corev1.Container{Name: "app"},
+			allowed:   true,
+		},
+		{
+			desc:        "invalid pod annotation",
+			namespace:   "default",
+			annotations: map[string]string{containerValueAnnotationPrefix + "app": "two"},
+			owned:       true,
+			container:   namedContainer("app", containerWithCPULimit("2")),
+			allowed:     false,
+			expected: []recordedEvent{{
+				Object:    replicaSet,
+				EventType: corev1.EventTypeWarning,
+				Reason:    eventReasonInvalidAnnotation,
+				Message:   `invalid annotation gomaxprocs-injector/value.app="two": must be a positive integer`,
+			}},
+		},
+		{
+			desc:        "invalid pod annotation in a dry run",
+			namespace:   "default",
+			annotations: map[string]string{minAnnotationKey: "zero"},
+			owned:       true,
+			container:   namedContainer("app", containerWithCPULimit("2")),
+			dryRun:      true,
+			allowed:     false,
+		},
+		{
+			desc:      "invalid namespace annotation, on the namespace",
+			namespace: "invalid",
+			owned:     true,
+			container: namedContainer("app", containerWithCPULimit("2")),
+			allowed:   true,
+			expected: []recordedEvent{{
+				Object:    corev1.ObjectReference{APIVersion: "v1", Kind: "Namespace", Namespace: "invalid", Name: "invalid", UID: "invalid-uid"},
+				EventType: corev1.EventTypeWarning,
+				Reason:    eventReasonInvalidAnnotation,
+				Message:   `namespace invalid: ignoring invalid annotation gomaxprocs-injector/min="zero": must be an integer no less than 0`,
+			}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			config := DefaultConfig()
+			config.Enforce = true
+			if tc.mode != "" {
+				config.Mode = tc.mode
+			}
+			recorder := &testRecorder{}
+			c := NewController(config,
+				WithNamespaceLister(corelisters.NewNamespaceLister(namespaces)),
+				WithEventRecorder(recorder),
+			)
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "app-",
+					Namespace:    tc.namespace,
+					Annotations:  tc.annotations,
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{tc.container}},
+			}
+			if tc.owned {
+				pod.GenerateName = "app-5d4f8b9c7-"
+				pod.OwnerReferences = []metav1.OwnerReference{owner}
+			}
+
+			res := c.admit(context.Background(), v1.AdmissionReview{
+				Request: &v1.AdmissionRequest{
+					Resource:  podResource,
+					Operation: v1.Create,
+					Namespace: tc.namespace,
+					DryRun:    &tc.dryRun,
+					Object:    newPodObjectFromPod(t, pod),
+				},
+			})
+			if res.Allowed != tc.allowed {
+				t.Fatalf("expected %v, got %v: %v", tc.allowed, res.Allowed, res.Result)
+			}
+			if diff := cmp.Diff(tc.expected, recorder.events); diff != "" {
+				t.Errorf("unexpected events (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
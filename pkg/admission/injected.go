@@ -0,0 +1,120 @@
+package admission
+
+import (
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// injectedAnnotationKey holds the variables the webhook set on each container
+// of a pod, so that a later invocation of the webhook for the same pod, such
+// as a reinvocation after another webhook changed the pod, can tell them apart
+// from variables set by the user.
+var injectedAnnotationKey = "gomaxprocs-injector/injected"
+
+// injectedValues maps container names to the variables the webhook set on the
+// container and their values.
+type injectedValues map[string]map[string]string
+
+// parseInjectedValues returns the values recorded on the pod by a previous
+// invocation. An invalid annotation is ignored, in which case all variables
+// are considered set by the user.
+func parseInjectedValues(pod *corev1.Pod) injectedValues {
+	value, ok := pod.Annotations[injectedAnnotationKey]
+	if !ok {
+		return nil
+	}
+
+	var values injectedValues
+	if err := json.Unmarshal([]byte(value), &values); err != nil {
+		klog.ErrorS(err, "Ignoring invalid annotation", "annotation", injectedAnnotationKey)
+		return nil
+	}
+	return values
+}
+
+// carryOverInjected records the variables a previous invocation set on the
+// containers of the pod that still have the value it set, so that they are
+// kept track of even if the containers are not mutated this time.
+func (m *mutation) carryOverInjected() {
+	forEachContainer(m.pod, func(container *corev1.Container) {
+		for name := range m.previous[container.Name] {
+			if m.ownsEnv(container, name) {
+				m.recordInjected(container, name, m.previous[container.Name][name])
+			}
+		}
+	})
+}
+
+// ownsEnv reports whether the variable of the container was set by a previous
+// invocation and still has the value it set. Otherwise, the variable is either
+// unset or was set or changed by someone else.
+func (m *mutation) ownsEnv(container *corev1.Container, name string) bool {
+	value, ok := m.previous[container.Name][name]
+	if !ok {
+		return false
+	}
+	env := findEnv(container, name)
+	return env != nil && env.ValueFrom == nil && env.Value == value
+}
+
+// setEnv sets the variable of the container, updating it in place if a
+// previous invocation set it.
+func (m *mutation) setEnv(container *corev1.Container, name, value string) {
+	if m.ownsEnv(container, name) {
+		findEnv(container, name).Value = value
+	} else {
+		container.Env = append(container.Env, corev1.EnvVar{
+			Name:  name,
+			Value: value,
+		})
+	}
+	m.recordInjected(container, name, value)
+}
+
+// unsetEnv removes a variable a previous invocation set, once it no longer
+// applies to the container.
+func (m *mutation) unsetEnv(container *corev1.Container, name string) {
+	if !m.ownsEnv(container, name) {
+		return
+	}
+
+	for i := range container.Env {
+		if container.Env[i].Name == name {
+			container.Env = append(container.Env[:i], container.Env[i+1:]...)
+			break
+		}
+	}
+	if len(container.Env) == 0 {
+		container.Env = nil
+	}
+	m.forgetInjected(container, name)
+}
+
+// forgetInjected removes the record that the webhook set the variable of the
+// container.
+func (m *mutation) forgetInjected(container *corev1.Container, name string) {
+	delete(m.injected[container.Name], name)
+	if len(m.injected[container.Name]) == 0 {
+		delete(m.injected, container.Name)
+	}
+}
+
+// recordInjected records that the webhook set the variable of the container.
+func (m *mutation) recordInjected(container *corev1.Container, name, value string) {
+	if m.injected[container.Name] == nil {
+		m.injected[container.Name] = map[string]string{}
+	}
+	m.injected[container.Name][name] = value
+}
+
+// forEachContainer calls f with the init containers and containers of the pod.
+func forEachContainer(pod *corev1.Pod, f func(container *corev1.Container)) {
+	for i := range pod.Spec.InitContainers {
+		f(&pod.Spec.InitContainers[i])
+	}
+	for i := range pod.Spec.Containers {
+		f(&pod.Spec.Containers[i])
+	}
+}
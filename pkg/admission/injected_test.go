@@ -0,0 +1,244 @@
+package admission
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAdmitReinvocation(t *testing.T) {
+	config := DefaultConfig()
+	config.GOMEMLIMIT.Enabled = true
+	c := NewController(config)
+
+	withEnv := func(container corev1.Container, env ...corev1.EnvVar) corev1.Container {
+		container.Env = env
+		return container
+	}
+	withMemoryLimit := func(container corev1.Container, memory string) corev1.Container {
+		container.Resources.Limits[corev1.ResourceMemory] = containerWithMemoryLimit(memory).Resources.Limits[corev1.ResourceMemory]
+		return container
+	}
+
+	testCases := []struct {
+		desc        string
+		annotations map[string]string
+		containers  []corev1.Container
+
+		expectedAnnotations map[string]string
+		expectedEnvs        [][]corev1.EnvVar
+	}{
+		{
+			desc: "unchanged pod is left alone",
+			annotations: map[string]string{
+				"gomaxprocs-injector/injected": `{"app":{"GOMAXPROCS":"2","GOMEMLIMIT":"90"}}`,
+			},
+			containers: []corev1.Container{
+				withEnv(namedContainer("app", withMemoryLimit(containerWithCPULimit("2"), "100")),
+					corev1.EnvVar{Name: "GOMAXPROCS", Value: "2"},
+					corev1.EnvVar{Name: "GOMEMLIMIT", Value: "90"},
+				),
+			},
+			expectedAnnotations: map[string]string{
+				"gomaxprocs-injector/injected": `{"app":{"GOMAXPROCS":"2","GOMEMLIMIT":"90"}}`,
+			},
+			expectedEnvs: [][]corev1.EnvVar{
+				{{Name: "GOMAXPROCS", Value: "2"}, {Name: "GOMEMLIMIT", Value: "90"}},
+			},
+		},
+		{
+			desc: "changed limits and added containers",
+			annotations: map[string]string{
+				"gomaxprocs-injector/injected": `{"app":{"GOMAXPROCS":"2","GOMEMLIMIT":"90"}}`,
+			},
+			containers: []corev1.Container{
+				withEnv(namedContainer("app", withMemoryLimit(containerWithCPULimit("4"), "200")),
+					corev1.EnvVar{Name: "GOMAXPROCS", Value: "2"},
+					corev1.EnvVar{Name: "OTHER", Value: "x"},
+					corev1.EnvVar{Name: "GOMEMLIMIT", Value: "90"},
+				),
+				namedContainer("sidecar", containerWithCPULimit("1")),
+				withEnv(namedContainer("user", containerWithCPULimit("1")),
+					corev1.EnvVar{Name: "GOMAXPROCS", Value: "8"},
+				),
+			},
+			expectedAnnotations: map[string]string{
+				"gomaxprocs-injector/injected": `{"app":{"GOMAXPROCS":"4","GOMEMLIMIT":"180"},"sidecar":{"GOMAXPROCS":"1"}}`,
+			},
+			expectedEnvs: [][]corev1.EnvVar{
+				{{Name: "GOMAXPROCS", Value: "4"}, {Name: "OTHER", Value: "x"}, {Name: "GOMEMLIMIT", Value: "180"}},
+				{{Name: "GOMAXPROCS", Value: "1"}},
+				{{Name: "GOMAXPROCS", Value: "8"}},
+			},
+		},
+		{
+			desc: "values changed by someone else are left alone",
+			annotations: map[string]string{
+				"gomaxprocs-injector/injected": `{"app":{"GOMAXPROCS":"2"}}`,
+			},
+			containers: []corev1.Container{
+				withEnv(namedContainer("app", containerWithCPULimit("4")),
+					corev1.EnvVar{Name: "GOMAXPROCS", Value: "3"},
+				),
+			},
+			expectedEnvs: [][]corev1.EnvVar{
+				{{Name: "GOMAXPROCS", Value: "3"}},
+			},
+		},
+		{
+			desc: "values are removed with the limits",
+			annotations: map[string]string{
+				"gomaxprocs-injector/injected": `{"app":{"GOMAXPROCS":"2"}}`,
+			},
+			containers: []corev1.Container{
+				withEnv(namedContainer("app", corev1.Container{}),
+					corev1.EnvVar{Name: "GOMAXPROCS", Value: "2"},
+				),
+			},
+			expectedEnvs: [][]corev1.EnvVar{
+				nil,
+			},
+		},
+		{
+			desc: "invalid annotation is ignored",
+			annotations: map[string]string{
+				"gomaxprocs-injector/injected": `invalid`,
+			},
+			containers: []corev1.Container{
+				withEnv(namedContainer("app", containerWithCPULimit("4")),
+					corev1.EnvVar{Name: "GOMAXPROCS", Value: "2"},
+				),
+			},
+			expectedEnvs: [][]corev1.EnvVar{
+				{{Name: "GOMAXPROCS", Value: "2"}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			review := v1.AdmissionReview{
+				Request: &v1.AdmissionRequest{
+					Resource: metav1.GroupVersionResource{
+						Group:    "",
+						Version:  "v1",
+						Resource: "pods",
+					},
+					Object: newPodObjectFromPod(t, &corev1.Pod{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:        "test-pod",
+							Namespace:   "default",
+							Annotations: tc.annotations,
+						},
+						Spec: corev1.PodSpec{
+							Containers: tc.containers,
+						},
+					}),
+				},
+			}
+
+			res := c.admit(context.Background(), review)
+			if !res.Allowed {
+				t.Fatalf("expected allowed, got %v", res.Result)
+			}
+			checkPatch(t, review.Request.Object.Raw, res.Patch, func(expectedPod *corev1.Pod) {
+				expectedPod.Annotations = tc.expectedAnnotations
+				for i, env := range tc.expectedEnvs {
+					expectedPod.Spec.Containers[i].Env = env
+				}
+			})
+		})
+	}
+}
+
+func TestAdmitReinvocationEnforce(t *testing.T) {
+	config := DefaultConfig()
+	config.Enforce = true
+	c := NewController(config)
+
+	withEnv := func(container corev1.Container, value string) corev1.Container {
+		container.Env = []corev1.EnvVar{{Name: "GOMAXPROCS", Value: value}}
+		return container
+	}
+
+	testCases := []struct {
+		desc        string
+		annotations map[string]string
+		container   corev1.Container
+
+		expectedAnnotations map[string]string
+		expectedEnv         []corev1.EnvVar
+	}{
+		{
+			desc: "overridden value stands",
+			annotations: map[string]string{
+				"gomaxprocs-injector/injected":   `{"app":{"GOMAXPROCS":"2"}}`,
+				"gomaxprocs-injector/overridden": `{"app":{"value":"3","reason":"3 exceeds the maximum of 2 for the cpu resource limit"}}`,
+			},
+			container: withEnv(namedContainer("app", containerWithCPULimit("2")), "2"),
+			expectedAnnotations: map[string]string{
+				"gomaxprocs-injector/injected":   `{"app":{"GOMAXPROCS":"2"}}`,
+				"gomaxprocs-injector/overridden": `{"app":{"value":"3","reason":"3 exceeds the maximum of 2 for the cpu resource limit"}}`,
+			},
+			expectedEnv: []corev1.EnvVar{{Name: "GOMAXPROCS", Value: "2"}},
+		},
+		{
+			desc: "original value within the raised limit is restored",
+			annotations: map[string]string{
+				"gomaxprocs-injector/injected":   `{"app":{"GOMAXPROCS":"2"}}`,
+				"gomaxprocs-injector/overridden": `{"app":{"value":"3","reason":"3 exceeds the maximum of 2 for the cpu resource limit"}}`,
+			},
+			container:   withEnv(namedContainer("app", containerWithCPULimit("4")), "2"),
+			expectedEnv: []corev1.EnvVar{{Name: "GOMAXPROCS", Value: "3"}},
+		},
+		{
+			desc: "original value exceeding the raised limit is overridden again",
+			annotations: map[string]string{
+				"gomaxprocs-injector/injected":   `{"app":{"GOMAXPROCS":"2"}}`,
+				"gomaxprocs-injector/overridden": `{"app":{"value":"8","reason":"8 exceeds the maximum of 2 for the cpu resource limit"}}`,
+			},
+			container: withEnv(namedContainer("app", containerWithCPULimit("4")), "2"),
+			expectedAnnotations: map[string]string{
+				"gomaxprocs-injector/injected":   `{"app":{"GOMAXPROCS":"4"}}`,
+				"gomaxprocs-injector/overridden": `{"app":{"value":"8","reason":"8 exceeds the maximum of 2 for the cpu resource limit"}}`,
+			},
+			expectedEnv: []corev1.EnvVar{{Name: "GOMAXPROCS", Value: "4"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			review := v1.AdmissionReview{
+				Request: &v1.AdmissionRequest{
+					Resource: metav1.GroupVersionResource{
+						Group:    "",
+						Version:  "v1",
+						Resource: "pods",
+					},
+					Object: newPodObjectFromPod(t, &corev1.Pod{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:        "test-pod",
+							Namespace:   "default",
+							Annotations: tc.annotations,
+						},
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{tc.container},
+						},
+					}),
+				},
+			}
+
+			res := c.admit(context.Background(), review)
+			if !res.Allowed {
+				t.Fatalf("expected allowed, got %v", res.Result)
+			}
+			checkPatch(t, review.Request.Object.Raw, res.Patch, func(expectedPod *corev1.Pod) {
+				expectedPod.Annotations = tc.expectedAnnotations
+				expectedPod.Spec.Containers[0].Env = tc.expectedEnv
+			})
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package admission
+
+import (
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+// limitRange holds the constraints of the LimitRanges of a namespace that the
+// LimitRanger admission plugin applies to the containers of a new pod. Mutating
+// webhooks may run before or after LimitRanger, so the limits of a container
+// are resolved against them either way.
+type limitRange struct {
+	// defaults are the default limits of containers. LimitRanger applies the
+	// first default it finds for a resource.
+	defaults corev1.ResourceList
+	// max are the maximum limits of containers, the lowest of all
+	// LimitRanges.
+	max corev1.ResourceList
+	// podMax are the maximum limits of pods, the lowest of all LimitRanges.
+	podMax corev1.ResourceList
+}
+
+// limitRange returns the constraints of the LimitRanges in the namespace from
+// the cache, or nil if the Controller has no LimitRange cache or there are no
+// LimitRanges.
+func (c *Controller) limitRange(namespace string) *limitRange {
+	if c.limitRangeLister == nil {
+		return nil
+	}
+
+	limitRanges, err := c.limitRangeLister.LimitRanges(namespace).List(labels.Everything())
+	if err != nil {
+		klog.ErrorS(err, "Failed to list LimitRanges", "namespace", namespace)
+		return nil
+	}
+	if len(limitRanges) == 0 {
+		return nil
+	}
+	sort.Slice(limitRanges, func(i, j int) bool { return limitRanges[i].Name < limitRanges[j].Name })
+
+	l := &limitRange{
+		defaults: corev1.ResourceList{},
+		max:      corev1.ResourceList{},
+		podMax:   corev1.ResourceList{},
+	}
+	for _, limitRange := range limitRanges {
+		for _, item := range limitRange.Spec.Limits {
+			switch item.Type {
+			case corev1.LimitTypeContainer:
+				for name, quantity := range item.Default {
+					if _, ok := l.defaults[name]; !ok {
+						l.defaults[name] = quantity
+					}
+				}
+				lowest(l.max, item.Max)
+			case corev1.LimitTypePod:
+				lowest(l.podMax, item.Max)
+			}
+		}
+	}
+	return l
+}
+
+// lowest lowers the quantities in list to the ones in other.
+func lowest(list, other corev1.ResourceList) {
+	for name, quantity := range other {
+		if current, ok := list[name]; !ok || quantity.Cmp(current) < 0 {
+			list[name] = quantity
+		}
+	}
+}
+
+// containerLimit returns the limit of the resource the container will run
+// with. A container without a limit gets the default, or the maximum if there
+// is no default, and a limit above the maximum is capped to it; LimitRanger
+// rejects such a pod anyway.
+func (l *limitRange) containerLimit(container *corev1.Container, name corev1.ResourceName) resource.Quantity {
+	limit := container.Resources.Limits[name]
+	if l == nil {
+		return limit
+	}
+
+	max, hasMax := l.max[name]
+	if limit.IsZero() {
+		if defaultLimit, ok := l.defaults[name]; ok {
+			limit = defaultLimit
+		} else if hasMax {
+			limit = max
+		}
+	}
+	if hasMax && limit.Cmp(max) > 0 {
+		limit = max
+	}
+	return limit
+}
+
+// podLimit returns the maximum limit of the resource for a pod, or zero if
+// there is none.
+func (l *limitRange) podLimit(name corev1.ResourceName) resource.Quantity {
+	if l == nil {
+		return resource.Quantity{}
+	}
+	return l.podMax[name]
+}
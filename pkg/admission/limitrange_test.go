@@ -0,0 +1,164 @@
+package admission
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestAdmitLimitRange(t *testing.T) {
+	limitRanges := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, limitRange := range []*corev1.LimitRange{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a"},
+			Spec: corev1.LimitRangeSpec{
+				Limits: []corev1.LimitRangeItem{
+					{
+						Type: corev1.LimitTypeContainer,
+						Default: corev1.ResourceList{
+							corev1.ResourceCPU:    resource.MustParse("2"),
+							corev1.ResourceMemory: resource.MustParse("200"),
+						},
+						Max: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("6")},
+					},
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "b"},
+			Spec: corev1.LimitRangeSpec{
+				Limits: []corev1.LimitRangeItem{
+					{
+						Type:    corev1.LimitTypeContainer,
+						Default: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("3")},
+						Max:     corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("5")},
+					},
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "max-only", Name: "max"},
+			Spec: corev1.LimitRangeSpec{
+				Limits: []corev1.LimitRangeItem{
+					{
+						Type: corev1.LimitTypeContainer,
+						Max:  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("3")},
+					},
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "pod-max", Name: "pod"},
+			Spec: corev1.LimitRangeSpec{
+				Limits: []corev1.LimitRangeItem{
+					{
+						Type:    corev1.LimitTypeContainer,
+						Default: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")},
+					},
+					{
+						Type: corev1.LimitTypePod,
+						Max:  corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("3")},
+					},
+				},
+			},
+		},
+	} {
+		if err := limitRanges.Add(limitRange); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := DefaultConfig()
+	config.GOMEMLIMIT.Enabled = true
+	c := NewController(config, WithLimitRangeLister(corelisters.NewLimitRangeLister(limitRanges)))
+
+	testCases := []struct {
+		desc       string
+		namespace  string
+		containers []corev1.Container
+
+		expectedEnvs [][]corev1.EnvVar
+	}{
+		{
+			desc:      "no LimitRanges",
+			namespace: "other",
+			containers: []corev1.Container{
+				{},
+				containerWithCPULimit("2"),
+			},
+			expectedEnvs: [][]corev1.EnvVar{
+				nil,
+				{{Name: "GOMAXPROCS", Value: "2"}},
+			},
+		},
+		{
+			desc:      "first default and lowest max",
+			namespace: "default",
+			containers: []corev1.Container{
+				{},
+				containerWithCPULimit("8"),
+				containerWithCPULimit("1"),
+			},
+			expectedEnvs: [][]corev1.EnvVar{
+				{{Name: "GOMAXPROCS", Value: "2"}, {Name: "GOMEMLIMIT", Value: "180"}},
+				{{Name: "GOMAXPROCS", Value: "5"}, {Name: "GOMEMLIMIT", Value: "180"}},
+				{{Name: "GOMAXPROCS", Value: "1"}, {Name: "GOMEMLIMIT", Value: "180"}},
+			},
+		},
+		{
+			desc:      "max without default",
+			namespace: "max-only",
+			containers: []corev1.Container{
+				{},
+			},
+			expectedEnvs: [][]corev1.EnvVar{
+				{{Name: "GOMAXPROCS", Value: "3"}},
+			},
+		},
+		{
+			desc:      "pod max",
+			namespace: "pod-max",
+			containers: []corev1.Container{
+				{},
+			},
+			expectedEnvs: [][]corev1.EnvVar{
+				{{Name: "GOMAXPROCS", Value: "3"}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			review := v1.AdmissionReview{
+				Request: &v1.AdmissionRequest{
+					Resource: metav1.GroupVersionResource{
+						Group:    "",
+						Version:  "v1",
+						Resource: "pods",
+					},
+					Namespace: tc.namespace,
+					Object: newPodObjectFromPod(t, &corev1.Pod{
+						ObjectMeta: metav1.ObjectMeta{Name: "test-pod"},
+						Spec:       corev1.PodSpec{Containers: tc.containers},
+					}),
+				},
+			}
+
+			res := c.admit(context.Background(), review)
+			if !res.Allowed {
+				t.Fatalf("expected allowed, got %v", res.Result)
+			}
+			checkPatch(t, review.Request.Object.Raw, res.Patch, func(expectedPod *corev1.Pod) {
+				for i, env := range tc.expectedEnvs {
+					expectedPod.Spec.Containers[i].Env = env
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package admission
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gjkim42/gomaxprocs-injector/pkg/gomaxprocs"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+var (
+	strategyAnnotationKey          = "gomaxprocs-injector/strategy"
+	strategyToleranceAnnotationKey = "gomaxprocs-injector/strategy-tolerance"
+	minAnnotationKey               = "gomaxprocs-injector/min"
+	maxAnnotationKey               = "gomaxprocs-injector/max"
+	gomemlimitPercentAnnotationKey = "gomaxprocs-injector/gomemlimit-percent"
+	modeAnnotationKey              = "gomaxprocs-injector/mode"
+)
+
+// namespaceInjectValue returns the inject setting of the namespace. The
+// annotation takes precedence over the label.
+func namespaceInjectValue(namespace *corev1.Namespace, injectAnnotationKey string) string {
+	if value, ok := namespace.Annotations[injectAnnotationKey]; ok {
+		return value
+	}
+	return namespace.Labels[injectAnnotationKey]
+}
+
+// namespaceConfig returns the policy for pods in the namespace, in which the
+// annotations of the namespace override the server defaults. Invalid
+// annotations are ignored and reported as warnings, as the author of the pod
+// cannot fix them. Unlike the other settings, the mode can only be set on the
+// namespace.
+func namespaceConfig(config Config, namespace *corev1.Namespace) (Config, []string) {
+	if namespace == nil {
+		return config, nil
+	}
+
+	errs := applyPolicyAnnotations(&config, namespace.Annotations)
+	if value, ok := namespace.Annotations[modeAnnotationKey]; ok {
+		if err := Mode(value).Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid annotation %s: %w", modeAnnotationKey, err))
+		} else {
+			config.Mode = Mode(value)
+		}
+	}
+
+	var warnings []string
+	for _, err := range errs {
+		klog.ErrorS(err, "Ignoring invalid namespace annotation", "namespace", namespace.Name)
+		warnings = append(warnings, fmt.Sprintf("namespace %s: ignoring %v", namespace.Name, err))
+	}
+	return config, warnings
+}
+
+// podConfig returns the policy for the pod, in which the annotations of the
+// pod override config. Invalid pod annotations are returned as an error.
+func podConfig(config Config, pod *corev1.Pod) (Config, error) {
+	if errs := applyPolicyAnnotations(&config, pod.Annotations); len(errs) > 0 {
+		return config, annotationError{errs[0]}
+	}
+	return config, nil
+}
+
+// applyPolicyAnnotations overrides config with the policy annotations found in
+// annotations. Each annotation is applied on its own, so an invalid one does
+// not prevent the others from being applied.
+func applyPolicyAnnotations(config *Config, annotations map[string]string) []error {
+	var errs []error
+
+	if name, ok := annotations[strategyAnnotationKey]; ok {
+		var tolerance int64
+		if err := parseIntAnnotation(annotations, strategyToleranceAnnotationKey, 0, &tolerance); err != nil {
+			errs = append(errs, err)
+		} else if strategy, err := gomaxprocs.New(name, tolerance); err != nil {
+			errs = append(errs, fmt.Errorf("invalid annotation %s=%q: %w", strategyAnnotationKey, name, err))
+		} else {
+			config.Strategy = strategy
+		}
+	} else if _, ok := annotations[strategyToleranceAnnotationKey]; ok {
+		errs = append(errs, fmt.Errorf("annotation %s requires %s", strategyToleranceAnnotationKey, strategyAnnotationKey))
+	}
+
+	bounds := *config
+	minErr := parseIntAnnotation(annotations, minAnnotationKey, 0, &bounds.Min)
+	maxErr := parseIntAnnotation(annotations, maxAnnotationKey, 0, &bounds.Max)
+	switch {
+	case minErr != nil || maxErr != nil:
+		for _, err := range []error{minErr, maxErr} {
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	case bounds.ValidateBounds() != nil:
+		errs = append(errs, fmt.Errorf("invalid annotations %s and %s: %w", minAnnotationKey, maxAnnotationKey, bounds.ValidateBounds()))
+	default:
+		config.Min, config.Max = bounds.Min, bounds.Max
+	}
+
+	if _, ok := annotations[gomemlimitPercentAnnotationKey]; ok {
+		gomemlimit := config.GOMEMLIMIT
+		if err := parseIntAnnotation(annotations, gomemlimitPercentAnnotationKey, 1, &gomemlimit.Percent); err != nil {
+			errs = append(errs, err)
+		} else if err := gomemlimit.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid annotation %s: %w", gomemlimitPercentAnnotationKey, err))
+		} else {
+			config.GOMEMLIMIT = gomemlimit
+		}
+	}
+
+	return errs
+}
+
+// parseIntAnnotation parses the annotation key into v if it is set. The value
+// must be an integer no less than min.
+func parseIntAnnotation(annotations map[string]string, key string, min int64, v *int64) error {
+	value, ok := annotations[key]
+	if !ok {
+		return nil
+	}
+
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || i < min {
+		return fmt.Errorf("invalid annotation %s=%q: must be an integer no less than %d", key, value, min)
+	}
+	*v = i
+	return nil
+}
@@ -0,0 +1,327 @@
+package admission
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestAdmitNamespaceDefaults(t *testing.T) {
+	namespaces := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, namespace := range []*corev1.Namespace{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "ceil",
+				Annotations: map[string]string{
+					"gomaxprocs-injector/strategy":           "ceil",
+					"gomaxprocs-injector/max":                "3",
+					"gomaxprocs-injector/gomemlimit-percent": "50",
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "disabled",
+				Annotations: map[string]string{
+					"gomaxprocs-injector/inject": "disabled",
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "invalid",
+				Annotations: map[string]string{
+					"gomaxprocs-injector/strategy": "unknown",
+					"gomaxprocs-injector/min":      "2",
+				},
+			},
+		},
+	} {
+		if err := namespaces.Add(namespace); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := DefaultConfig()
+	config.GOMEMLIMIT.Enabled = true
+	c := NewController(config, WithNamespaceLister(corelisters.NewNamespaceLister(namespaces)))
+
+	withMemoryLimit := func(container corev1.Container, memory string) corev1.Container {
+		container.Resources.Limits[corev1.ResourceMemory] = resource.MustParse(memory)
+		return container
+	}
+
+	testCases := []struct {
+		desc        string
+		namespace   string
+		annotations map[string]string
+
+		allowed      bool
+		warnings     int
+		expectedEnvs [][]corev1.EnvVar
+	}{
+		{
+			desc:      "namespace defaults",
+			namespace: "ceil",
+			allowed:   true,
+			expectedEnvs: [][]corev1.EnvVar{
+				{{Name: "GOMAXPROCS", Value: "2"}, {Name: "GOMEMLIMIT", Value: "50"}},
+				{{Name: "GOMAXPROCS", Value: "3"}, {Name: "GOMEMLIMIT", Value: "50"}},
+			},
+		},
+		{
+			desc:      "pod annotations take precedence",
+			namespace: "ceil",
+			annotations: map[string]string{
+				"gomaxprocs-injector/strategy":           "floor",
+				"gomaxprocs-injector/max":                "0",
+				"gomaxprocs-injector/gomemlimit-percent": "90",
+			},
+			allowed: true,
+			expectedEnvs: [][]corev1.EnvVar{
+				{{Name: "GOMAXPROCS", Value: "1"}, {Name: "GOMEMLIMIT", Value: "90"}},
+				{{Name: "GOMAXPROCS", Value: "4"}, {Name: "GOMEMLIMIT", Value: "90"}},
+			},
+		},
+		{
+			desc:      "namespace disabled",
+			namespace: "disabled",
+			allowed:   true,
+			expectedEnvs: [][]corev1.EnvVar{
+				nil,
+				nil,
+			},
+		},
+		{
+			desc:      "pod enabled in disabled namespace",
+			namespace: "disabled",
+			annotations: map[string]string{
+				"gomaxprocs-injector/inject": "enabled",
+			},
+			allowed: true,
+			expectedEnvs: [][]corev1.EnvVar{
+				{{Name: "GOMAXPROCS", Value: "1"}, {Name: "GOMEMLIMIT", Value: "90"}},
+				{{Name: "GOMAXPROCS", Value: "4"}, {Name: "GOMEMLIMIT", Value: "90"}},
+			},
+		},
+		{
+			desc:      "invalid namespace annotations are ignored",
+			namespace: "invalid",
+			allowed:   true,
+			warnings:  1,
+			expectedEnvs: [][]corev1.EnvVar{
+				{{Name: "GOMAXPROCS", Value: "2"}, {Name: "GOMEMLIMIT", Value: "90"}},
+				{{Name: "GOMAXPROCS", Value: "4"}, {Name: "GOMEMLIMIT", Value: "90"}},
+			},
+		},
+		{
+			desc:      "invalid pod annotations are denied",
+			namespace: "ceil",
+			annotations: map[string]string{
+				"gomaxprocs-injector/min": "3",
+				"gomaxprocs-injector/max": "2",
+			},
+			allowed: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			review := v1.AdmissionReview{
+				Request: &v1.AdmissionRequest{
+					Resource: metav1.GroupVersionResource{
+						Group:    "",
+						Version:  "v1",
+						Resource: "pods",
+					},
+					Namespace: tc.namespace,
+					Object: newPodObjectFromPod(t, &corev1.Pod{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:        "test-pod",
+							Annotations: tc.annotations,
+						},
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{
+								withMemoryLimit(containerWithCPULimit("1500m"), "100"),
+								withMemoryLimit(containerWithCPULimit("4"), "100"),
+							},
+						},
+					}),
+				},
+			}
+
+			res := c.admit(context.Background(), review)
+			if res.Allowed != tc.allowed {
+				t.Fatalf("expected %v, got %v: %v", tc.allowed, res.Allowed, res.Result)
+			}
+			if !tc.allowed {
+				return
+			}
+			if len(res.Warnings) != tc.warnings {
+				t.Errorf("expected %d warnings, got %v", tc.warnings, res.Warnings)
+			}
+			checkPatch(t, review.Request.Object.Raw, res.Patch, func(expectedPod *corev1.Pod) {
+				for i, env := range tc.expectedEnvs {
+					expectedPod.Spec.Containers[i].Env = env
+				}
+			})
+		})
+	}
+}
+
+func TestAdmitReportOnly(t *testing.T) {
+	namespaces := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, namespace := range []*corev1.Namespace{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "report-only",
+				Annotations: map[string]string{"gomaxprocs-injector/mode": "report-only"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "mutate",
+				Annotations: map[string]string{"gomaxprocs-injector/mode": "mutate"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "invalid",
+				Annotations: map[string]string{"gomaxprocs-injector/mode": "dry-run"},
+			},
+		},
+	} {
+		if err := namespaces.Add(namespace); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		desc      string
+		mode      Mode
+		namespace string
+
+		reportOnly bool
+		warnings   int
+	}{
+		{
+			desc:      "server default",
+			mode:      ModeReportOnly,
+			namespace: "default",
+
+			reportOnly: true,
+			// A summary and the decision for the container.
+			warnings: 2,
+		},
+		{
+			desc:      "namespace in report-only mode",
+			mode:      ModeMutate,
+			namespace: "report-only",
+
+			reportOnly: true,
+			warnings:   2,
+		},
+		{
+			desc:      "namespace in mutate mode",
+			mode:      ModeReportOnly,
+			namespace: "mutate",
+		},
+		{
+			desc:      "invalid namespace annotation is ignored",
+			mode:      ModeReportOnly,
+			namespace: "invalid",
+
+			reportOnly: true,
+			warnings:   3,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			config := DefaultConfig()
+			config.Mode = tc.mode
+			c := NewController(config, WithNamespaceLister(corelisters.NewNamespaceLister(namespaces)))
+
+			review := v1.AdmissionReview{
+				Request: &v1.AdmissionRequest{
+					Resource: metav1.GroupVersionResource{
+						Group:    "",
+						Version:  "v1",
+						Resource: "pods",
+					},
+					Namespace: tc.namespace,
+					Object: newPodObjectFromPod(t, &corev1.Pod{
+						ObjectMeta: metav1.ObjectMeta{Name: "test-pod"},
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{namedContainer("app", containerWithCPULimit("2"))},
+						},
+					}),
+				},
+			}
+
+			res := c.admit(context.Background(), review)
+			if !res.Allowed {
+				t.Fatalf("expected allowed, got %v", res.Result)
+			}
+			if res.AuditAnnotations["decisions"] == "" {
+				t.Errorf("expected decisions audit annotation, got %v", res.AuditAnnotations)
+			}
+			if !tc.reportOnly {
+				if res.Patch == nil {
+					t.Errorf("expected patch")
+				}
+				if _, ok := res.AuditAnnotations["patch"]; ok {
+					t.Errorf("expected no patch audit annotation, got %v", res.AuditAnnotations)
+				}
+				return
+			}
+
+			if res.Patch != nil || res.PatchType != nil {
+				t.Errorf("expected no patch, got %s", res.Patch)
+			}
+			// The patch that would have been applied is reported instead.
+			checkPatch(t, review.Request.Object.Raw, []byte(res.AuditAnnotations["patch"]), func(expectedPod *corev1.Pod) {
+				expectedPod.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "GOMAXPROCS", Value: "2"}}
+			})
+			if len(res.Warnings) != tc.warnings {
+				t.Errorf("expected %d warnings, got %v", tc.warnings, res.Warnings)
+			}
+		})
+	}
+}
+
+func TestEffectiveConfig(t *testing.T) {
+	namespaces := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	if err := namespaces.Add(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "staging",
+			Annotations: map[string]string{
+				minAnnotationKey:      "2",
+				strategyAnnotationKey: "unknown",
+				modeAnnotationKey:     "report-only",
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	c := NewController(DefaultConfig(), WithNamespaceLister(corelisters.NewNamespaceLister(namespaces)))
+
+	config, warnings := c.EffectiveConfig("staging")
+	if config.Min != 2 || config.Strategy.Name() != DefaultConfig().Strategy.Name() || config.Mode != ModeReportOnly {
+		t.Errorf("expected min 2, the default strategy and report-only mode, got min %d, strategy %s and mode %s", config.Min, config.Strategy.Name(), config.Mode)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("expected a warning about the strategy annotation, got %v", warnings)
+	}
+
+	config, warnings = c.EffectiveConfig("")
+	if config.Min != 0 || config.Mode != ModeMutate || len(warnings) != 0 {
+		t.Errorf("expected the server defaults, got min %d, mode %s and warnings %v", config.Min, config.Mode, warnings)
+	}
+}
@@ -0,0 +1,122 @@
+package admission
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gjkim42/gomaxprocs-injector/pkg/apis/gomaxprocs/v1alpha1"
+	"github.com/gjkim42/gomaxprocs-injector/pkg/gomaxprocs"
+	"github.com/gjkim42/gomaxprocs-injector/pkg/policy"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// containerConfig returns the policy for the container. The settings of the
+// matching ClusterGOMAXPROCSPolicy override the namespace defaults, the
+// settings of the matching GOMAXPROCSPolicy override those, and the
+// annotations of the pod override everything.
+func (c *Controller) containerConfig(m *mutation, container *corev1.Container) Config {
+	if c.policies == nil {
+		return m.config
+	}
+
+	match := policy.Resolve(c.policies, m.pod, m.namespace, container)
+	for _, conflict := range match.Conflicts {
+		names := make([]string, len(conflict))
+		for i, ref := range conflict {
+			names[i] = ref.String()
+		}
+		klog.InfoS("Conflicting policies match container", "container", container.Name, "policies", names, "applied", names[0])
+		m.warn(fmt.Sprintf("container %q: %s match with the same priority, applying %s", container.Name, strings.Join(names, ", "), names[0]))
+		m.policyConflicts = append(m.policyConflicts, conflict)
+	}
+	if match.Cluster == nil && match.Namespaced == nil {
+		return m.config
+	}
+
+	config := m.namespaceConfig
+	if match.Cluster != nil {
+		m.applyPolicy(&config, policy.Ref{Name: match.Cluster.Name}, match.Cluster.Spec.Settings)
+	}
+	if match.Namespaced != nil {
+		m.applyPolicy(&config, policy.Ref{Namespace: match.Namespaced.Namespace, Name: match.Namespaced.Name}, match.Namespaced.Spec.Settings)
+	}
+	for _, err := range applyPolicyAnnotations(&config, m.pod.Annotations) {
+		m.warn(fmt.Sprintf("container %q: ignoring %v", container.Name, err))
+	}
+	return config
+}
+
+// applyPolicy applies the settings of a policy to config and records that the
+// policy was applied.
+func (m *mutation) applyPolicy(config *Config, ref policy.Ref, settings v1alpha1.PolicySettings) {
+	klog.InfoS("Applying policy", "policy", ref)
+	m.appliedPolicies[ref] = true
+	for _, err := range applyPolicySettings(config, settings) {
+		klog.ErrorS(err, "Ignoring invalid policy setting", "policy", ref)
+		m.warn(fmt.Sprintf("%s: ignoring %v", ref, err))
+	}
+}
+
+// applyPolicySettings overrides config with the settings that are set. Like
+// applyPolicyAnnotations, each setting is applied on its own.
+func applyPolicySettings(config *Config, settings v1alpha1.PolicySettings) []error {
+	var errs []error
+
+	if settings.Strategy != "" {
+		var tolerance int64
+		if settings.StrategyTolerance != nil {
+			tolerance = *settings.StrategyTolerance
+		}
+		if strategy, err := gomaxprocs.New(settings.Strategy, tolerance); err != nil {
+			errs = append(errs, fmt.Errorf("invalid strategy %q: %w", settings.Strategy, err))
+		} else {
+			config.Strategy = strategy
+		}
+	} else if settings.StrategyTolerance != nil {
+		errs = append(errs, fmt.Errorf("strategyTolerance requires strategy"))
+	}
+
+	bounds := *config
+	if settings.Min != nil {
+		bounds.Min = *settings.Min
+	}
+	if settings.Max != nil {
+		bounds.Max = *settings.Max
+	}
+	if err := bounds.ValidateBounds(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid min and max: %w", err))
+	} else {
+		config.Min, config.Max = bounds.Min, bounds.Max
+	}
+
+	if settings.Enforce != nil {
+		config.Enforce = *settings.Enforce
+	}
+
+	if settings.GOMEMLIMITPercent != nil {
+		gomemlimit := config.GOMEMLIMIT
+		gomemlimit.Percent = *settings.GOMEMLIMITPercent
+		if err := gomemlimit.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid gomemlimitPercent: %w", err))
+		} else {
+			config.GOMEMLIMIT = gomemlimit
+		}
+	}
+
+	return errs
+}
+
+// recordPolicies reports the policies applied to the pod to the tracker, which
+// maintains their status.
+func (c *Controller) recordPolicies(m *mutation) {
+	if c.policyTracker == nil || (len(m.appliedPolicies) == 0 && len(m.policyConflicts) == 0) {
+		return
+	}
+
+	applied := make([]policy.Ref, 0, len(m.appliedPolicies))
+	for ref := range m.appliedPolicies {
+		applied = append(applied, ref)
+	}
+	c.policyTracker.Record(applied, m.policyConflicts)
+}
@@ -0,0 +1,200 @@
+package admission
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gjkim42/gomaxprocs-injector/pkg/apis/gomaxprocs/v1alpha1"
+	"github.com/gjkim42/gomaxprocs-injector/pkg/policy"
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestAdmitPolicies(t *testing.T) {
+	int64Ptr := func(i int64) *int64 { return &i }
+
+	namespaces := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, namespace := range []*corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-b", Labels: map[string]string{"team": "b"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "conflict"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "invalid"}},
+	} {
+		if err := namespaces.Add(namespace); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	policies := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, p := range []*v1alpha1.GOMAXPROCSPolicy{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+			Spec: v1alpha1.GOMAXPROCSPolicySpec{
+				Containers: []v1alpha1.ContainerMatcher{{Name: "web"}},
+				Settings:   v1alpha1.PolicySettings{Strategy: "floor"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "conflict", Name: "a"},
+			Spec: v1alpha1.GOMAXPROCSPolicySpec{
+				Containers: []v1alpha1.ContainerMatcher{{Name: "web"}},
+				Settings:   v1alpha1.PolicySettings{Max: int64Ptr(1)},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "conflict", Name: "b"},
+			Spec: v1alpha1.GOMAXPROCSPolicySpec{
+				Containers: []v1alpha1.ContainerMatcher{{Name: "web"}},
+				Settings:   v1alpha1.PolicySettings{Max: int64Ptr(3)},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "invalid", Name: "p"},
+			Spec: v1alpha1.GOMAXPROCSPolicySpec{
+				Settings: v1alpha1.PolicySettings{StrategyTolerance: int64Ptr(100), Max: int64Ptr(3)},
+			},
+		},
+	} {
+		if err := policies.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clusterPolicies := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, p := range []*v1alpha1.ClusterGOMAXPROCSPolicy{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "all"},
+			Spec: v1alpha1.ClusterGOMAXPROCSPolicySpec{
+				GOMAXPROCSPolicySpec: v1alpha1.GOMAXPROCSPolicySpec{
+					Settings: v1alpha1.PolicySettings{Strategy: "ceil", GOMEMLIMITPercent: int64Ptr(50)},
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "team-b"},
+			Spec: v1alpha1.ClusterGOMAXPROCSPolicySpec{
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "b"}},
+				GOMAXPROCSPolicySpec: v1alpha1.GOMAXPROCSPolicySpec{
+					Priority: 1,
+					Settings: v1alpha1.PolicySettings{Max: int64Ptr(2)},
+				},
+			},
+		},
+	} {
+		if err := clusterPolicies.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := DefaultConfig()
+	config.GOMEMLIMIT.Enabled = true
+	c := NewController(config,
+		WithNamespaceLister(corelisters.NewNamespaceLister(namespaces)),
+		WithPolicies(policy.NewLister(policies, clusterPolicies), nil),
+	)
+
+	withMemoryLimit := func(container corev1.Container, memory string) corev1.Container {
+		container.Resources.Limits[corev1.ResourceMemory] = resource.MustParse(memory)
+		return container
+	}
+
+	testCases := []struct {
+		desc        string
+		namespace   string
+		annotations map[string]string
+
+		warnings     int
+		expectedEnvs [][]corev1.EnvVar
+	}{
+		{
+			desc:      "namespaced policy overrides cluster policy",
+			namespace: "default",
+			expectedEnvs: [][]corev1.EnvVar{
+				{{Name: "GOMAXPROCS", Value: "1"}, {Name: "GOMEMLIMIT", Value: "50"}},
+				{{Name: "GOMAXPROCS", Value: "4"}, {Name: "GOMEMLIMIT", Value: "50"}},
+			},
+		},
+		{
+			desc:      "pod annotations override policies",
+			namespace: "default",
+			annotations: map[string]string{
+				"gomaxprocs-injector/strategy":           "round",
+				"gomaxprocs-injector/gomemlimit-percent": "90",
+			},
+			expectedEnvs: [][]corev1.EnvVar{
+				{{Name: "GOMAXPROCS", Value: "2"}, {Name: "GOMEMLIMIT", Value: "90"}},
+				{{Name: "GOMAXPROCS", Value: "4"}, {Name: "GOMEMLIMIT", Value: "90"}},
+			},
+		},
+		{
+			desc:      "cluster policy with higher priority and namespace selector",
+			namespace: "team-b",
+			expectedEnvs: [][]corev1.EnvVar{
+				{{Name: "GOMAXPROCS", Value: "1"}, {Name: "GOMEMLIMIT", Value: "90"}},
+				{{Name: "GOMAXPROCS", Value: "2"}, {Name: "GOMEMLIMIT", Value: "90"}},
+			},
+		},
+		{
+			desc:      "conflicting policies",
+			namespace: "conflict",
+			warnings:  1,
+			expectedEnvs: [][]corev1.EnvVar{
+				{{Name: "GOMAXPROCS", Value: "1"}, {Name: "GOMEMLIMIT", Value: "50"}},
+				{{Name: "GOMAXPROCS", Value: "4"}, {Name: "GOMEMLIMIT", Value: "50"}},
+			},
+		},
+		{
+			desc:      "invalid policy settings are ignored",
+			namespace: "invalid",
+			warnings:  1,
+			expectedEnvs: [][]corev1.EnvVar{
+				{{Name: "GOMAXPROCS", Value: "2"}, {Name: "GOMEMLIMIT", Value: "50"}},
+				{{Name: "GOMAXPROCS", Value: "3"}, {Name: "GOMEMLIMIT", Value: "50"}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			review := v1.AdmissionReview{
+				Request: &v1.AdmissionRequest{
+					Resource: metav1.GroupVersionResource{
+						Group:    "",
+						Version:  "v1",
+						Resource: "pods",
+					},
+					Namespace: tc.namespace,
+					Object: newPodObjectFromPod(t, &corev1.Pod{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:        "test-pod",
+							Annotations: tc.annotations,
+						},
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{
+								namedContainer("web", withMemoryLimit(containerWithCPULimit("1500m"), "100")),
+								namedContainer("worker", withMemoryLimit(containerWithCPULimit("4"), "100")),
+							},
+						},
+					}),
+				},
+			}
+
+			res := c.admit(context.Background(), review)
+			if !res.Allowed {
+				t.Fatalf("expected allowed, got %v", res.Result)
+			}
+			if len(res.Warnings) != tc.warnings {
+				t.Errorf("expected %d warnings, got %v", tc.warnings, res.Warnings)
+			}
+			checkPatch(t, review.Request.Object.Raw, res.Patch, func(expectedPod *corev1.Pod) {
+				for i, env := range tc.expectedEnvs {
+					expectedPod.Spec.Containers[i].Env = env
+				}
+			})
+		})
+	}
+}
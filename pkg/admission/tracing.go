@@ -0,0 +1,69 @@
+package admission
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// tracerName is the instrumentation scope of the spans of the Controller.
+const tracerName = "github.com/gjkim42/gomaxprocs-injector/pkg/admission"
+
+// Names of the spans of an admission request. The spans of the other stages
+// are children of spanServeHTTP.
+const (
+	spanServeHTTP = "ServeHTTP"
+	spanDecode    = "decode"
+	spanAdmit     = "admit"
+	spanCompare   = "jsondiff.Compare"
+	spanMarshal   = "marshal"
+)
+
+// Attributes of the spans of an admission request.
+const (
+	attributeWebhook         = attribute.Key("admission.webhook")
+	attributeAPIVersion      = attribute.Key("admission.api_version")
+	attributeUID             = attribute.Key("admission.request.uid")
+	attributeOperation       = attribute.Key("admission.request.operation")
+	attributeResource        = attribute.Key("admission.request.resource")
+	attributeSubResource     = attribute.Key("admission.request.sub_resource")
+	attributeDryRun          = attribute.Key("admission.request.dry_run")
+	attributeAllowed         = attribute.Key("admission.response.allowed")
+	attributeMessage         = attribute.Key("admission.response.message")
+	attributePatchOperations = attribute.Key("admission.response.patch_operations")
+	attributeNamespace       = attribute.Key("k8s.namespace.name")
+	attributePodName         = attribute.Key("k8s.pod.name")
+	attributePodGenerateName = attribute.Key("k8s.pod.generate_name")
+)
+
+// requestAttributes describes an admission request in span attributes.
+func requestAttributes(request *v1.AdmissionRequest) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attributeUID.String(string(request.UID)),
+		attributeOperation.String(string(request.Operation)),
+		attributeNamespace.String(request.Namespace),
+		attributeResource.String(request.Resource.String()),
+		attributeSubResource.String(request.SubResource),
+		attributeDryRun.Bool(request.DryRun != nil && *request.DryRun),
+	}
+}
+
+// podAttributes identifies a pod in span attributes. Pods created by
+// controllers have no name yet, only a generateName.
+func podAttributes(pod *corev1.Pod) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attributePodName.String(pod.Name),
+		attributePodGenerateName.String(pod.GenerateName),
+	}
+}
+
+// endSpan ends span, marking it as failed if err is not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,93 @@
+package admission
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestServeHTTPTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	c := NewController(DefaultConfig(), WithTracerProvider(tp))
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "test-"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{containerWithCPULimit("2")}},
+	}
+	req := newReviewRequest(t, &v1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &v1.AdmissionRequest{
+			UID:       "1",
+			Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+			Operation: v1.Create,
+			Namespace: "default",
+			Object:    newPodObjectFromPod(t, pod),
+		},
+	})
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	c.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := map[string]tracetest.SpanStub{}
+	var names []string
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+		names = append(names, span.Name)
+	}
+	// Spans are exported as they end, children first.
+	expectedNames := []string{spanDecode, spanCompare, spanAdmit, spanMarshal, spanServeHTTP}
+	if diff := cmp.Diff(expectedNames, names); diff != "" {
+		t.Fatalf("unexpected spans (-want +got):\n%s", diff)
+	}
+
+	root := spans[spanServeHTTP]
+	if got := root.Parent.SpanID().String(); got != "b7ad6b7169203331" {
+		t.Errorf("expected the span of the API server as parent, got %s", got)
+	}
+	if got := root.SpanContext.TraceID().String(); got != "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("expected the trace of the API server, got %s", got)
+	}
+	for _, name := range []string{spanDecode, spanAdmit, spanMarshal} {
+		if spans[name].Parent.SpanID() != root.SpanContext.SpanID() {
+			t.Errorf("expected %s to be a child of %s", name, spanServeHTTP)
+		}
+	}
+	if spans[spanCompare].Parent.SpanID() != spans[spanAdmit].SpanContext.SpanID() {
+		t.Errorf("expected %s to be a child of %s", spanCompare, spanAdmit)
+	}
+
+	expectAttributes(t, root.Attributes, map[attribute.Key]attribute.Value{
+		attributeWebhook:    attribute.StringValue(mutatingWebhook),
+		attributeAPIVersion: attribute.StringValue("v1"),
+		attributeUID:        attribute.StringValue("1"),
+		attributeOperation:  attribute.StringValue("CREATE"),
+		attributeNamespace:  attribute.StringValue("default"),
+		attributeAllowed:    attribute.BoolValue(true),
+	})
+	expectAttributes(t, spans[spanAdmit].Attributes, map[attribute.Key]attribute.Value{
+		attributePodGenerateName: attribute.StringValue("test-"),
+	})
+	expectAttributes(t, spans[spanCompare].Attributes, map[attribute.Key]attribute.Value{
+		attributePatchOperations: attribute.IntValue(2),
+	})
+}
+
+func expectAttributes(t *testing.T, attributes []attribute.KeyValue, expected map[attribute.Key]attribute.Value) {
+	t.Helper()
+	actual := map[attribute.Key]attribute.Value{}
+	for _, kv := range attributes {
+		actual[kv.Key] = kv.Value
+	}
+	for key, value := range expected {
+		if actual[key] != value {
+			t.Errorf("expected attribute %s to be %v, got %v", key, value.Emit(), actual[key].Emit())
+		}
+	}
+}
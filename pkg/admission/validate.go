@@ -0,0 +1,190 @@
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gjkim42/gomaxprocs-injector/pkg/gomaxprocs"
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+)
+
+// validationAnnotationKey sets the ValidationAction of a namespace.
+var validationAnnotationKey = "gomaxprocs-injector/validation"
+
+// ValidationAction decides what happens to a pod whose GOMAXPROCS is
+// misconfigured.
+type ValidationAction string
+
+const (
+	// ValidationActionWarn admits the pod with admission warnings.
+	ValidationActionWarn ValidationAction = "warn"
+	// ValidationActionDeny rejects the pod.
+	ValidationActionDeny ValidationAction = "deny"
+)
+
+// Validate checks that the action is known.
+func (a ValidationAction) Validate() error {
+	switch a {
+	case ValidationActionWarn, ValidationActionDeny:
+		return nil
+	default:
+		return fmt.Errorf("unknown validation action %q, must be %q or %q", a, ValidationActionWarn, ValidationActionDeny)
+	}
+}
+
+// validate checks the GOMAXPROCS of the containers of a pod after all
+// mutating webhooks have run. Values recorded by the mutating webhook are
+// trusted if they are still the ones it computes for the pod; anything else
+// is checked, which catches values changed by webhooks that run after it.
+func (c *Controller) validate(ctx context.Context, review v1.AdmissionReview) *v1.AdmissionResponse {
+	if review.Request.Resource != podResource {
+		err := fmt.Errorf("expected resource to be %s", podResource)
+		klog.ErrorS(err, "Failed to validate")
+		return toV1AdmissionResponse(err)
+	}
+
+	var pod corev1.Pod
+	if err := json.Unmarshal(review.Request.Object.Raw, &pod); err != nil {
+		klog.ErrorS(err, "Failed to unmarshal pod")
+		return toV1AdmissionResponse(err)
+	}
+	if pod.Namespace == "" {
+		pod.Namespace = review.Request.Namespace
+	}
+
+	var containers []*corev1.Container
+	switch {
+	case review.Request.SubResource == "":
+		forEachContainer(&pod, func(container *corev1.Container) {
+			containers = append(containers, container)
+		})
+	case review.Request.SubResource == ephemeralContainersSubResource && review.Request.Operation == v1.Update:
+		var oldPod corev1.Pod
+		if err := json.Unmarshal(review.Request.OldObject.Raw, &oldPod); err != nil {
+			klog.ErrorS(err, "Failed to unmarshal old pod")
+			return toV1AdmissionResponse(err)
+		}
+		existing := make(map[string]bool, len(oldPod.Spec.EphemeralContainers))
+		for _, container := range oldPod.Spec.EphemeralContainers {
+			existing[container.Name] = true
+		}
+		for i := range pod.Spec.EphemeralContainers {
+			if !existing[pod.Spec.EphemeralContainers[i].Name] {
+				containers = append(containers, (*corev1.Container)(&pod.Spec.EphemeralContainers[i].EphemeralContainerCommon))
+			}
+		}
+	default:
+		err := fmt.Errorf("unsupported operation %s on subresource %q of %s", review.Request.Operation, review.Request.SubResource, podResource)
+		klog.ErrorS(err, "Failed to validate")
+		return toV1AdmissionResponse(err)
+	}
+
+	m, err := c.newPodMutation(&pod)
+	if err != nil {
+		klog.ErrorS(err, "Failed to validate")
+		return toV1AdmissionResponse(err)
+	}
+	if m == nil {
+		return &v1.AdmissionResponse{
+			Allowed: true,
+		}
+	}
+
+	var problems []string
+	for _, container := range containers {
+		problems = append(problems, c.validateContainer(m, container)...)
+	}
+	if len(problems) == 0 {
+		return &v1.AdmissionResponse{
+			Allowed: true,
+		}
+	}
+
+	action := validationAction(c.Config().ValidationAction, m.namespace)
+	klog.InfoS("Pod has misconfigured GOMAXPROCS", "pod", klog.KObj(&pod), "problems", problems, "action", action)
+	if action == ValidationActionDeny {
+		return toV1AdmissionResponse(fmt.Errorf("misconfigured GOMAXPROCS: %s", strings.Join(problems, "; ")))
+	}
+	return &v1.AdmissionResponse{
+		Allowed:  true,
+		Warnings: problems,
+	}
+}
+
+// validateContainer returns the problems with the GOMAXPROCS of the
+// container, set either in env or through envFrom.
+func (c *Controller) validateContainer(m *mutation, container *corev1.Container) []string {
+	// Anyone can write the injected annotation, so a value recorded in it is
+	// only trusted if the mutating webhook would set it.
+	if m.ownsEnv(container, gomaxprocsEnvName) {
+		gomaxProcs, _, ok := m.computeGOMAXPROCS(c.containerConfig(m, container), container)
+		if ok && envValue(container, gomaxprocsEnvName) == strconv.FormatInt(gomaxProcs, 10) {
+			return nil
+		}
+	}
+
+	env := findEnv(container, gomaxprocsEnvName)
+	if env != nil && env.ValueFrom != nil {
+		// The value cannot be known before the container starts.
+		return nil
+	}
+
+	var problems []string
+	value, found := "", false
+	if env != nil {
+		value, found = env.Value, true
+	}
+	if c.resolvesEnvFrom(m) && len(container.EnvFrom) > 0 {
+		fromValue, fromFound, err := c.envFrom.lookup(m.pod.Namespace, container, gomaxprocsEnvName)
+		switch {
+		case err != nil:
+			klog.ErrorS(err, "Failed to resolve envFrom", "container", container.Name, "env", gomaxprocsEnvName)
+		case fromFound && found && fromValue != value:
+			problems = append(problems, fmt.Sprintf("container %q: GOMAXPROCS is %q in env but %q in envFrom", container.Name, value, fromValue))
+		case fromFound && !found:
+			value, found = fromValue, true
+		}
+	}
+	if !found {
+		return problems
+	}
+
+	gomaxProcs, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || gomaxProcs < 1 {
+		return append(problems, fmt.Sprintf("container %q: GOMAXPROCS %q is not a positive integer", container.Name, value))
+	}
+
+	cpuLimit := m.effectiveCPULimit(container)
+	if cpuLimit == 0 {
+		return append(problems, fmt.Sprintf("container %q: GOMAXPROCS is set to %d but the container has no CPU limit", container.Name, gomaxProcs))
+	}
+	if max := gomaxprocs.Ceil().Compute(cpuLimit); gomaxProcs > max {
+		return append(problems, fmt.Sprintf("container %q: GOMAXPROCS %d exceeds the CPU limit of %s", container.Name, gomaxProcs, resource.NewMilliQuantity(cpuLimit, resource.DecimalSI)))
+	}
+	return problems
+}
+
+// validationAction returns the action for pods in the namespace. The
+// annotation of the namespace overrides the server default. An invalid
+// annotation is ignored.
+func validationAction(action ValidationAction, namespace *corev1.Namespace) ValidationAction {
+	if namespace == nil {
+		return action
+	}
+
+	value, ok := namespace.Annotations[validationAnnotationKey]
+	if !ok {
+		return action
+	}
+	if err := ValidationAction(value).Validate(); err != nil {
+		klog.ErrorS(err, "Ignoring invalid namespace annotation", "namespace", namespace.Name, "annotation", validationAnnotationKey)
+		return action
+	}
+	return ValidationAction(value)
+}
@@ -0,0 +1,274 @@
+package admission
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestValidate(t *testing.T) {
+	namespaces := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, namespace := range []*corev1.Namespace{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "strict",
+				Annotations: map[string]string{"gomaxprocs-injector/validation": "deny"},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "invalid",
+				Annotations: map[string]string{"gomaxprocs-injector/validation": "sometimes"},
+			},
+		},
+	} {
+		if err := namespaces.Add(namespace); err != nil {
+			t.Fatal(err)
+		}
+	}
+	configMaps := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, namespace := range []string{"default", "strict", "invalid"} {
+		if err := configMaps.Add(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "runtime", Namespace: namespace},
+			Data:       map[string]string{"GOMAXPROCS": "1"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	secrets := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	config := DefaultConfig()
+	config.ResolveEnvFrom = true
+	c := NewController(config,
+		WithNamespaceLister(corelisters.NewNamespaceLister(namespaces)),
+		WithEnvFromListers(corelisters.NewConfigMapLister(configMaps), corelisters.NewSecretLister(secrets)),
+	)
+
+	withGOMAXPROCS := func(container corev1.Container, value string) corev1.Container {
+		container.Env = append(container.Env, corev1.EnvVar{Name: "GOMAXPROCS", Value: value})
+		return container
+	}
+	withEnvFrom := func(container corev1.Container) corev1.Container {
+		container.EnvFrom = []corev1.EnvFromSource{{
+			ConfigMapRef: &corev1.ConfigMapEnvSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "runtime"},
+			},
+		}}
+		return container
+	}
+
+	testCases := []struct {
+		desc        string
+		namespace   string
+		annotations map[string]string
+		containers  []corev1.Container
+
+		allowed  bool
+		warnings int
+	}{
+		{
+			desc:      "valid",
+			namespace: "default",
+			containers: []corev1.Container{
+				containerWithCPULimit("2"),
+				withGOMAXPROCS(containerWithCPULimit("1500m"), "2"),
+				withEnvFrom(containerWithCPULimit("1")),
+			},
+			allowed: true,
+		},
+		{
+			desc:      "not a positive integer",
+			namespace: "default",
+			containers: []corev1.Container{
+				withGOMAXPROCS(containerWithCPULimit("2"), "two"),
+				withGOMAXPROCS(containerWithCPULimit("2"), "0"),
+			},
+			allowed:  true,
+			warnings: 2,
+		},
+		{
+			desc:      "no CPU limit",
+			namespace: "default",
+			containers: []corev1.Container{
+				withGOMAXPROCS(corev1.Container{}, "2"),
+			},
+			allowed:  true,
+			warnings: 1,
+		},
+		{
+			desc:      "exceeds the CPU limit",
+			namespace: "default",
+			containers: []corev1.Container{
+				withGOMAXPROCS(containerWithCPULimit("1500m"), "3"),
+			},
+			allowed:  true,
+			warnings: 1,
+		},
+		{
+			desc:      "env differs from envFrom",
+			namespace: "default",
+			containers: []corev1.Container{
+				withGOMAXPROCS(withEnvFrom(containerWithCPULimit("2")), "2"),
+			},
+			allowed:  true,
+			warnings: 1,
+		},
+		{
+			desc:      "set by the mutating webhook",
+			namespace: "default",
+			annotations: map[string]string{
+				"gomaxprocs-injector/min":      "3",
+				"gomaxprocs-injector/injected": `{"":{"GOMAXPROCS":"3"}}`,
+			},
+			containers: []corev1.Container{
+				withGOMAXPROCS(containerWithCPULimit("1"), "3"),
+			},
+			allowed: true,
+		},
+		{
+			desc:      "not the value the mutating webhook sets",
+			namespace: "default",
+			annotations: map[string]string{
+				"gomaxprocs-injector/injected": `{"":{"GOMAXPROCS":"3"}}`,
+			},
+			containers: []corev1.Container{
+				withGOMAXPROCS(containerWithCPULimit("1"), "3"),
+			},
+			allowed:  true,
+			warnings: 1,
+		},
+		{
+			desc:      "forged annotation on a disabled container",
+			namespace: "strict",
+			annotations: map[string]string{
+				"gomaxprocs-injector/inject.app": "disabled",
+				"gomaxprocs-injector/injected":   `{"app":{"GOMAXPROCS":"64"}}`,
+			},
+			containers: []corev1.Container{
+				withGOMAXPROCS(namedContainer("app", containerWithCPULimit("1")), "64"),
+			},
+			allowed: false,
+		},
+		{
+			desc:      "injection disabled",
+			namespace: "default",
+			annotations: map[string]string{
+				"gomaxprocs-injector/inject": "disabled",
+			},
+			containers: []corev1.Container{
+				withGOMAXPROCS(containerWithCPULimit("1"), "3"),
+			},
+			allowed: true,
+		},
+		{
+			desc:      "denied by the namespace",
+			namespace: "strict",
+			containers: []corev1.Container{
+				withGOMAXPROCS(containerWithCPULimit("1"), "3"),
+			},
+			allowed: false,
+		},
+		{
+			desc:      "valid in a denying namespace",
+			namespace: "strict",
+			containers: []corev1.Container{
+				withGOMAXPROCS(containerWithCPULimit("1"), "1"),
+			},
+			allowed: true,
+		},
+		{
+			desc:      "invalid namespace annotation is ignored",
+			namespace: "invalid",
+			containers: []corev1.Container{
+				withGOMAXPROCS(containerWithCPULimit("1"), "3"),
+			},
+			allowed:  true,
+			warnings: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			review := v1.AdmissionReview{
+				Request: &v1.AdmissionRequest{
+					Resource: metav1.GroupVersionResource{
+						Group:    "",
+						Version:  "v1",
+						Resource: "pods",
+					},
+					Namespace: tc.namespace,
+					Object: newPodObjectFromPod(t, &corev1.Pod{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:        "test-pod",
+							Annotations: tc.annotations,
+						},
+						Spec: corev1.PodSpec{
+							Containers: tc.containers,
+						},
+					}),
+				},
+			}
+
+			res := c.validate(context.Background(), review)
+			if res.Allowed != tc.allowed {
+				t.Fatalf("expected %v, got %v: %v", tc.allowed, res.Allowed, res.Result)
+			}
+			if len(res.Warnings) != tc.warnings {
+				t.Errorf("expected %d warnings, got %v", tc.warnings, res.Warnings)
+			}
+			if res.Patch != nil {
+				t.Errorf("expected no patch, got %s", res.Patch)
+			}
+		})
+	}
+}
+
+func TestValidateEphemeralContainers(t *testing.T) {
+	c := NewController(DefaultConfig())
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-pod",
+			Namespace: "default",
+		},
+		Spec: corev1.PodSpec{
+			EphemeralContainers: []corev1.EphemeralContainer{
+				{EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+					Name: "existing",
+					Env:  []corev1.EnvVar{{Name: "GOMAXPROCS", Value: "two"}},
+				}},
+			},
+		},
+	}
+	oldObject := newPodObjectFromPod(t, pod)
+	pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name: "debugger",
+			Env:  []corev1.EnvVar{{Name: "GOMAXPROCS", Value: "0"}},
+		},
+	})
+
+	res := c.validate(context.Background(), v1.AdmissionReview{
+		Request: &v1.AdmissionRequest{
+			Resource: metav1.GroupVersionResource{
+				Group:    "",
+				Version:  "v1",
+				Resource: "pods",
+			},
+			SubResource: "ephemeralcontainers",
+			Operation:   v1.Update,
+			Object:      newPodObjectFromPod(t, pod),
+			OldObject:   oldObject,
+		},
+	})
+	if !res.Allowed {
+		t.Fatalf("expected allowed, got %v", res.Result)
+	}
+	if len(res.Warnings) != 1 {
+		t.Errorf("expected 1 warning for the new ephemeral container, got %v", res.Warnings)
+	}
+}
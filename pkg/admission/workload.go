@@ -0,0 +1,194 @@
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// workloadKind is a kind of workload whose pod template can be mutated.
+type workloadKind struct {
+	name     string
+	resource metav1.GroupVersionResource
+	// podSpecPath is the dot-separated path of the pod spec in the object.
+	podSpecPath string
+	// immutableTemplate tells that the pod template cannot be updated.
+	immutableTemplate bool
+	// newObject returns an empty object of the kind and its pod template.
+	newObject func() (interface{}, *corev1.PodTemplateSpec)
+}
+
+var workloadKinds = []workloadKind{
+	{
+		name:        "Deployment",
+		resource:    metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+		podSpecPath: "spec.template.spec",
+		newObject: func() (interface{}, *corev1.PodTemplateSpec) {
+			obj := &appsv1.Deployment{}
+			return obj, &obj.Spec.Template
+		},
+	},
+	{
+		name:        "StatefulSet",
+		resource:    metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"},
+		podSpecPath: "spec.template.spec",
+		newObject: func() (interface{}, *corev1.PodTemplateSpec) {
+			obj := &appsv1.StatefulSet{}
+			return obj, &obj.Spec.Template
+		},
+	},
+	{
+		name:        "DaemonSet",
+		resource:    metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "daemonsets"},
+		podSpecPath: "spec.template.spec",
+		newObject: func() (interface{}, *corev1.PodTemplateSpec) {
+			obj := &appsv1.DaemonSet{}
+			return obj, &obj.Spec.Template
+		},
+	},
+	{
+		name:              "Job",
+		resource:          metav1.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"},
+		podSpecPath:       "spec.template.spec",
+		immutableTemplate: true,
+		newObject: func() (interface{}, *corev1.PodTemplateSpec) {
+			obj := &batchv1.Job{}
+			return obj, &obj.Spec.Template
+		},
+	},
+	{
+		name:        "CronJob",
+		resource:    metav1.GroupVersionResource{Group: "batch", Version: "v1", Resource: "cronjobs"},
+		podSpecPath: "spec.jobTemplate.spec.template.spec",
+		newObject: func() (interface{}, *corev1.PodTemplateSpec) {
+			obj := &batchv1.CronJob{}
+			return obj, &obj.Spec.JobTemplate.Spec.Template
+		},
+	},
+}
+
+// WorkloadKinds returns the names of the workload kinds whose pod templates
+// can be mutated.
+func WorkloadKinds() []string {
+	names := make([]string, len(workloadKinds))
+	for i, kind := range workloadKinds {
+		names[i] = kind.name
+	}
+	return names
+}
+
+// ValidateWorkloadKinds checks that every name is one of WorkloadKinds.
+func ValidateWorkloadKinds(names []string) error {
+	for _, name := range names {
+		if _, ok := findWorkloadKind(name); !ok {
+			return fmt.Errorf("unsupported workload kind %q, must be one of: %s", name, strings.Join(WorkloadKinds(), ", "))
+		}
+	}
+	return nil
+}
+
+func findWorkloadKind(name string) (workloadKind, bool) {
+	for _, kind := range workloadKinds {
+		if kind.name == name {
+			return kind, true
+		}
+	}
+	return workloadKind{}, false
+}
+
+// workloadKind returns the enabled workload kind of the resource.
+func (c Config) workloadKind(resource metav1.GroupVersionResource) (workloadKind, bool) {
+	for _, name := range c.WorkloadKinds {
+		if kind, ok := findWorkloadKind(name); ok && kind.resource == resource {
+			return kind, true
+		}
+	}
+	return workloadKind{}, false
+}
+
+// admitWorkload mutates the pod template of a workload being created or
+// updated as if it were a pod. Updates that leave the template unchanged,
+// such as new labels on the workload, are not mutated, so that they do not
+// roll out new pods whenever the configuration has changed since the
+// template was mutated. Immutable templates are never mutated on update.
+func (c *Controller) admitWorkload(ctx context.Context, review v1.AdmissionReview, kind workloadKind) *v1.AdmissionResponse {
+	if review.Request.SubResource != "" {
+		err := fmt.Errorf("unsupported operation %s on subresource %q of %s", review.Request.Operation, review.Request.SubResource, kind.resource)
+		klog.ErrorS(err, "Failed to admit")
+		return toV1AdmissionResponse(err)
+	}
+
+	obj, _ := kind.newObject()
+	if err := json.Unmarshal(review.Request.Object.Raw, obj); err != nil {
+		klog.ErrorS(err, "Failed to unmarshal workload", "kind", kind.name)
+		return toV1AdmissionResponse(err)
+	}
+	newObj, newTemplate := kind.newObject()
+	if err := json.Unmarshal(review.Request.Object.Raw, newObj); err != nil {
+		klog.ErrorS(err, "Failed to unmarshal workload", "kind", kind.name)
+		return toV1AdmissionResponse(err)
+	}
+
+	ref := klog.KRef(review.Request.Namespace, review.Request.Name)
+	klog.InfoS("Admitting a workload", "kind", kind.name, "workload", ref)
+
+	if review.Request.Operation == v1.Update {
+		if kind.immutableTemplate {
+			klog.InfoS("Skipping workload update as its pod template is immutable", "kind", kind.name, "workload", ref)
+			return &v1.AdmissionResponse{
+				Allowed: true,
+			}
+		}
+		oldObj, oldTemplate := kind.newObject()
+		if err := json.Unmarshal(review.Request.OldObject.Raw, oldObj); err != nil {
+			klog.ErrorS(err, "Failed to unmarshal old workload", "kind", kind.name)
+			return toV1AdmissionResponse(err)
+		}
+		if equality.Semantic.DeepEqual(oldTemplate, newTemplate) {
+			klog.InfoS("Skipping workload update as its pod template is unchanged", "kind", kind.name, "workload", ref)
+			return &v1.AdmissionResponse{
+				Allowed: true,
+			}
+		}
+	}
+
+	// The pod stands in for the pods that will be created from the template.
+	// Only its annotations and spec are copied back into the template.
+	pod := &corev1.Pod{
+		ObjectMeta: *newTemplate.ObjectMeta.DeepCopy(),
+		Spec:       newTemplate.Spec,
+	}
+	pod.Namespace = review.Request.Namespace
+	pod.Name = review.Request.Name
+
+	m, err := c.newPodMutation(pod)
+	if err != nil {
+		klog.ErrorS(err, "Failed to admit")
+		return toV1AdmissionResponse(err)
+	}
+	if m == nil {
+		klog.InfoS("Skipping workload as injection is disabled", "kind", kind.name, "workload", ref)
+		return &v1.AdmissionResponse{
+			Allowed: true,
+		}
+	}
+	m.path = kind.podSpecPath
+	if err := c.mutatePodSpec(m); err != nil {
+		klog.ErrorS(err, "Failed to mutate pod template", "kind", kind.name)
+		return toV1AdmissionResponse(err)
+	}
+
+	newTemplate.Annotations = pod.Annotations
+	newTemplate.Spec = pod.Spec
+
+	return c.patchResponse(ctx, obj, newObj, m.outcome(), "workload", ref)
+}
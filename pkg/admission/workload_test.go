@@ -0,0 +1,219 @@
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/wI2L/jsondiff"
+	v1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestAdmitWorkloads(t *testing.T) {
+	config := DefaultConfig()
+	config.WorkloadKinds = []string{"Deployment", "Job", "CronJob"}
+	c := NewController(config)
+
+	template := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "test"}},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{namedContainer("app", containerWithCPULimit("2"))},
+		},
+	}
+	mutated := func(path string) corev1.PodTemplateSpec {
+		template := *template.DeepCopy()
+		template.Annotations = map[string]string{
+			injectedAnnotationKey:  `{"app":{"GOMAXPROCS":"2"}}`,
+			decisionsAnnotationKey: `[{"path":"` + path + `","container":"app","variable":"GOMAXPROCS","action":"injected","value":"2","cpuLimit":"2","strategy":"floor","reason":"cpu-limit"}]`,
+		}
+		template.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "GOMAXPROCS", Value: "2"}}
+		return template
+	}
+	mutatedTemplate := mutated("spec.template.spec")
+
+	disabledTemplate := *template.DeepCopy()
+	disabledTemplate.Annotations = map[string]string{injectAnnotationKey: injectDisabledValue}
+
+	deployment := func(template corev1.PodTemplateSpec) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"},
+			Spec:       appsv1.DeploymentSpec{Template: template},
+		}
+	}
+	labeled := func(deployment *appsv1.Deployment) *appsv1.Deployment {
+		deployment.Labels = map[string]string{"team": "test"}
+		return deployment
+	}
+	job := func(template corev1.PodTemplateSpec) *batchv1.Job {
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"},
+			Spec:       batchv1.JobSpec{Template: template},
+		}
+	}
+	cronJob := func(template corev1.PodTemplateSpec) *batchv1.CronJob {
+		cronJob := &batchv1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"},
+		}
+		cronJob.Spec.JobTemplate.Spec.Template = template
+		return cronJob
+	}
+
+	testCases := []struct {
+		desc        string
+		resource    metav1.GroupVersionResource
+		subResource string
+		// operation defaults to v1.Create.
+		operation v1.Operation
+		oldObject interface{}
+		object    interface{}
+
+		allowed  bool
+		expected interface{}
+	}{
+		{
+			desc:     "deployment",
+			resource: metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+			object:   deployment(template),
+			allowed:  true,
+			expected: deployment(mutatedTemplate),
+		},
+		{
+			desc:     "mutated deployment is left alone",
+			resource: metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+			object:   deployment(mutatedTemplate),
+			allowed:  true,
+			expected: deployment(mutatedTemplate),
+		},
+		{
+			desc:     "injection disabled in template",
+			resource: metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+			object:   deployment(disabledTemplate),
+			allowed:  true,
+			expected: deployment(disabledTemplate),
+		},
+		{
+			desc:      "update leaving template unchanged",
+			resource:  metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+			operation: v1.Update,
+			oldObject: deployment(template),
+			object:    labeled(deployment(template)),
+			allowed:   true,
+			expected:  labeled(deployment(template)),
+		},
+		{
+			desc:      "update changing template",
+			resource:  metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+			operation: v1.Update,
+			oldObject: deployment(disabledTemplate),
+			object:    deployment(template),
+			allowed:   true,
+			expected:  deployment(mutatedTemplate),
+		},
+		{
+			desc:     "job",
+			resource: metav1.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"},
+			object:   job(template),
+			allowed:  true,
+			expected: job(mutatedTemplate),
+		},
+		{
+			desc:      "job update",
+			resource:  metav1.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"},
+			operation: v1.Update,
+			oldObject: job(template),
+			object:    job(template),
+			allowed:   true,
+			expected:  job(template),
+		},
+		{
+			desc:     "cronjob",
+			resource: metav1.GroupVersionResource{Group: "batch", Version: "v1", Resource: "cronjobs"},
+			object:   cronJob(template),
+			allowed:  true,
+			expected: cronJob(mutated("spec.jobTemplate.spec.template.spec")),
+		},
+		{
+			desc:        "subresource",
+			resource:    metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+			subResource: "scale",
+			object:      deployment(template),
+			allowed:     false,
+		},
+		{
+			desc:     "kind not selected",
+			resource: metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"},
+			object:   &appsv1.StatefulSet{Spec: appsv1.StatefulSetSpec{Template: template}},
+			allowed:  false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			raw, err := json.Marshal(tc.object)
+			if err != nil {
+				t.Fatal(err)
+			}
+			review := v1.AdmissionReview{
+				Request: &v1.AdmissionRequest{
+					Resource:    tc.resource,
+					SubResource: tc.subResource,
+					Operation:   v1.Create,
+					Namespace:   "default",
+					Name:        "test",
+					Object:      runtime.RawExtension{Raw: raw},
+				},
+			}
+			if tc.operation != "" {
+				review.Request.Operation = tc.operation
+			}
+			if tc.oldObject != nil {
+				oldRaw, err := json.Marshal(tc.oldObject)
+				if err != nil {
+					t.Fatal(err)
+				}
+				review.Request.OldObject = runtime.RawExtension{Raw: oldRaw}
+			}
+
+			res := c.admit(context.Background(), review)
+			if res.Allowed != tc.allowed {
+				t.Fatalf("expected %v, got %v: %v", tc.allowed, res.Allowed, res.Result)
+			}
+			if !tc.allowed {
+				return
+			}
+
+			expectedPatch, err := jsondiff.Compare(tc.object, tc.expected)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(expectedPatch) == 0 {
+				if res.Patch != nil {
+					t.Errorf("expected no patch, got %s", res.Patch)
+				}
+				return
+			}
+			expectedPatchBytes, err := json.Marshal(expectedPatch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(string(expectedPatchBytes), string(res.Patch)); diff != "" {
+				t.Errorf("unexpected patch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestValidateWorkloadKinds(t *testing.T) {
+	if err := ValidateWorkloadKinds(WorkloadKinds()); err != nil {
+		t.Errorf("expected all kinds to be valid, got %v", err)
+	}
+	if err := ValidateWorkloadKinds([]string{"Deployment", "ReplicaSet"}); err == nil {
+		t.Errorf("expected ReplicaSet to be invalid")
+	}
+}
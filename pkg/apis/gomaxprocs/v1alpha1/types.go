@@ -0,0 +1,103 @@
+// Package v1alpha1 contains the v1alpha1 custom resources of the
+// gomaxprocs-injector.
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GroupName is the API group of the custom resources.
+const GroupName = "gomaxprocs-injector.gjkim42.io"
+
+var (
+	// SchemeGroupVersion is the group version of the custom resources.
+	SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1alpha1"}
+
+	// GOMAXPROCSPolicyResource is the resource of GOMAXPROCSPolicy.
+	GOMAXPROCSPolicyResource = SchemeGroupVersion.WithResource("gomaxprocspolicies")
+	// ClusterGOMAXPROCSPolicyResource is the resource of
+	// ClusterGOMAXPROCSPolicy.
+	ClusterGOMAXPROCSPolicyResource = SchemeGroupVersion.WithResource("clustergomaxprocspolicies")
+)
+
+// GOMAXPROCSPolicy sets the policy for matching containers of pods in its
+// namespace.
+type GOMAXPROCSPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   GOMAXPROCSPolicySpec `json:"spec"`
+	Status PolicyStatus         `json:"status,omitempty"`
+}
+
+// ClusterGOMAXPROCSPolicy sets the policy for matching containers of pods in
+// matching namespaces.
+type ClusterGOMAXPROCSPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterGOMAXPROCSPolicySpec `json:"spec"`
+	Status PolicyStatus                `json:"status,omitempty"`
+}
+
+// ClusterGOMAXPROCSPolicySpec is the spec of a ClusterGOMAXPROCSPolicy.
+type ClusterGOMAXPROCSPolicySpec struct {
+	// NamespaceSelector selects the namespaces the policy applies to. If
+	// empty, the policy applies to all namespaces.
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	GOMAXPROCSPolicySpec `json:",inline"`
+}
+
+// GOMAXPROCSPolicySpec is the spec of a GOMAXPROCSPolicy.
+type GOMAXPROCSPolicySpec struct {
+	// PodSelector selects the pods the policy applies to. If empty, the
+	// policy applies to all pods.
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+	// Containers restricts the policy to containers matching any of the
+	// matchers. If empty, the policy applies to all containers.
+	Containers []ContainerMatcher `json:"containers,omitempty"`
+	// Priority orders policies of the same scope that match the same
+	// container. The policy with the highest priority applies.
+	Priority int32 `json:"priority,omitempty"`
+	// Settings override the policy for matching containers.
+	Settings PolicySettings `json:"settings"`
+}
+
+// ContainerMatcher matches containers by name and image. Both are shell
+// patterns as understood by path.Match, and an empty pattern matches anything.
+type ContainerMatcher struct {
+	Name  string `json:"name,omitempty"`
+	Image string `json:"image,omitempty"`
+}
+
+// PolicySettings override the policy for matching containers. Settings that
+// are not set are left as they are.
+type PolicySettings struct {
+	Strategy          string `json:"strategy,omitempty"`
+	StrategyTolerance *int64 `json:"strategyTolerance,omitempty"`
+	Min               *int64 `json:"min,omitempty"`
+	Max               *int64 `json:"max,omitempty"`
+	Enforce           *bool  `json:"enforce,omitempty"`
+	GOMEMLIMITPercent *int64 `json:"gomemlimitPercent,omitempty"`
+}
+
+// PolicyStatus is the status of a GOMAXPROCSPolicy or ClusterGOMAXPROCSPolicy.
+type PolicyStatus struct {
+	// MatchedPods is the number of pods the policy was applied to during the
+	// last WindowSeconds, as seen by the replica that wrote the status.
+	MatchedPods int64 `json:"matchedPods"`
+	// WindowSeconds is the length of the window MatchedPods covers.
+	WindowSeconds int64 `json:"windowSeconds,omitempty"`
+	// LastMatchedTime is when the policy was last applied to a pod.
+	LastMatchedTime *metav1.Time `json:"lastMatchedTime,omitempty"`
+	// ConflictingPolicies lists the policies of the same scope and priority
+	// that matched the same containers during the window.
+	ConflictingPolicies []string `json:"conflictingPolicies,omitempty"`
+	// Conditions holds the Conflicting condition.
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// ConditionConflicting is true while the policy conflicts with another one.
+const ConditionConflicting = "Conflicting"
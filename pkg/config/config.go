@@ -0,0 +1,211 @@
+// Package config loads the configuration file of the gomaxprocs-injector and
+// reloads it when it changes.
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gjkim42/gomaxprocs-injector/pkg/admission"
+	"github.com/gjkim42/gomaxprocs-injector/pkg/gomaxprocs"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// Load reads and validates the configuration file at path.
+func Load(path string) (admission.Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return admission.Config{}, err
+	}
+	return Parse(data)
+}
+
+// Parse decodes and validates a configuration file. Unknown fields are
+// rejected.
+func Parse(data []byte) (admission.Config, error) {
+	var configuration Configuration
+	if err := yaml.UnmarshalStrict(data, &configuration); err != nil {
+		return admission.Config{}, fmt.Errorf("failed to decode configuration: %w", err)
+	}
+	if configuration.APIVersion != APIVersion || configuration.Kind != Kind {
+		return admission.Config{}, fmt.Errorf("unsupported configuration %s %s, expected %s %s", configuration.APIVersion, configuration.Kind, APIVersion, Kind)
+	}
+
+	config, err := configuration.toConfig()
+	if err != nil {
+		return admission.Config{}, err
+	}
+	config.Version = version(data)
+
+	if err := config.Validate(); err != nil {
+		return admission.Config{}, err
+	}
+	return config, nil
+}
+
+// toConfig converts the configuration file into the policy of the Controller,
+// filling in defaults for fields that are not set.
+func (c *Configuration) toConfig() (admission.Config, error) {
+	config := admission.DefaultConfig()
+
+	if c.InjectionMode != "" {
+		config.InjectionMode = admission.InjectionMode(c.InjectionMode)
+	}
+	if c.InjectAnnotationKey != "" {
+		config.InjectAnnotationKey = c.InjectAnnotationKey
+	}
+	if c.Mode != "" {
+		config.Mode = admission.Mode(c.Mode)
+	}
+	if c.InitContainers != "" {
+		config.InitContainers = admission.InitContainerPolicy(c.InitContainers)
+	}
+	config.DecisionWarnings = c.DecisionWarnings
+	config.ResolveEnvFrom = c.EnvFrom.Resolve
+	if c.EnvFrom.ErrorPolicy != "" {
+		config.EnvFromErrorPolicy = admission.EnvFromErrorPolicy(c.EnvFrom.ErrorPolicy)
+	}
+	if c.Validation.Action != "" {
+		config.ValidationAction = admission.ValidationAction(c.Validation.Action)
+	}
+
+	if c.GOMAXPROCS.Enabled != nil {
+		config.GOMAXPROCSEnabled = *c.GOMAXPROCS.Enabled
+	}
+	config.Enforce = c.GOMAXPROCS.Enforce
+	if c.GOMAXPROCS.Strategy != "" {
+		strategy, err := gomaxprocs.New(c.GOMAXPROCS.Strategy, c.GOMAXPROCS.StrategyTolerance)
+		if err != nil {
+			return admission.Config{}, err
+		}
+		config.Strategy = strategy
+	}
+	config.Min = c.GOMAXPROCS.Min
+	config.Max = c.GOMAXPROCS.Max
+
+	config.RequestFallback.Enabled = c.GOMAXPROCS.RequestFallback.Enabled
+	if c.GOMAXPROCS.RequestFallback.BurstMultiplier != 0 {
+		config.RequestFallback.BurstMultiplier = c.GOMAXPROCS.RequestFallback.BurstMultiplier
+	}
+	config.RequestFallback.Min = c.GOMAXPROCS.RequestFallback.Min
+	config.RequestFallback.Max = c.GOMAXPROCS.RequestFallback.Max
+
+	config.GOMEMLIMIT.Enabled = c.GOMEMLIMIT.Enabled
+	if c.GOMEMLIMIT.Percent != 0 {
+		config.GOMEMLIMIT.Percent = c.GOMEMLIMIT.Percent
+	}
+
+	config.WorkloadKinds = c.WorkloadKinds
+	for _, cr := range c.CustomResources {
+		config.CustomResources = append(config.CustomResources, admission.CustomResource{
+			Group:        cr.Group,
+			Version:      cr.Version,
+			Kind:         cr.Kind,
+			PodSpecPaths: cr.PodSpecPaths,
+		})
+	}
+
+	return config, nil
+}
+
+// FromConfig converts the policy of the Controller back into a configuration
+// file, with every default filled in.
+func FromConfig(config admission.Config) Configuration {
+	enabled := config.GOMAXPROCSEnabled
+	c := Configuration{
+		TypeMeta:            metav1.TypeMeta{APIVersion: APIVersion, Kind: Kind},
+		InjectionMode:       string(config.InjectionMode),
+		InjectAnnotationKey: config.InjectAnnotationKey,
+		Mode:                string(config.Mode),
+		InitContainers:      string(config.InitContainers),
+		DecisionWarnings:    config.DecisionWarnings,
+		GOMAXPROCS: GOMAXPROCSConfiguration{
+			Enabled: &enabled,
+			Enforce: config.Enforce,
+			Min:     config.Min,
+			Max:     config.Max,
+			RequestFallback: RequestFallbackConfiguration{
+				Enabled:         config.RequestFallback.Enabled,
+				BurstMultiplier: config.RequestFallback.BurstMultiplier,
+				Min:             config.RequestFallback.Min,
+				Max:             config.RequestFallback.Max,
+			},
+		},
+		GOMEMLIMIT: GOMEMLIMITConfiguration{
+			Enabled: config.GOMEMLIMIT.Enabled,
+			Percent: config.GOMEMLIMIT.Percent,
+		},
+		EnvFrom: EnvFromConfiguration{
+			Resolve:     config.ResolveEnvFrom,
+			ErrorPolicy: string(config.EnvFromErrorPolicy),
+		},
+		Validation:    ValidationConfiguration{Action: string(config.ValidationAction)},
+		WorkloadKinds: config.WorkloadKinds,
+	}
+	if config.Strategy != nil {
+		c.GOMAXPROCS.Strategy = config.Strategy.Name()
+		if s, ok := config.Strategy.(interface{ Tolerance() int64 }); ok {
+			c.GOMAXPROCS.StrategyTolerance = s.Tolerance()
+		}
+	}
+	for _, cr := range config.CustomResources {
+		c.CustomResources = append(c.CustomResources, CustomResourceConfiguration{
+			Group:        cr.Group,
+			Version:      cr.Version,
+			Kind:         cr.Kind,
+			PodSpecPaths: cr.PodSpecPaths,
+		})
+	}
+	return c
+}
+
+// Watch polls the configuration file at path every interval and calls update
+// with the new policy whenever its content changes. Polling, rather than
+// watching for file events, also picks up the symlink swap used to update
+// ConfigMaps mounted as volumes. An invalid file is logged and ignored, so the
+// last good policy stays in effect. Watch returns when ctx is done.
+func Watch(ctx context.Context, path string, interval time.Duration, current admission.Config, update func(admission.Config)) {
+	var rejected string
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			klog.ErrorS(err, "Failed to read configuration file, keeping the current configuration", "path", path, "version", current.Version)
+			continue
+		}
+		v := version(data)
+		if v == current.Version || v == rejected {
+			continue
+		}
+
+		config, err := Parse(data)
+		if err != nil {
+			klog.ErrorS(err, "Rejected invalid configuration file, keeping the current configuration", "path", path, "version", current.Version)
+			rejected = v
+			continue
+		}
+
+		klog.InfoS("Reloaded configuration file", "path", path, "version", config.Version, "previousVersion", current.Version)
+		current = config
+		update(config)
+	}
+}
+
+// version identifies the content of a configuration file.
+func version(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:12]
+}
@@ -0,0 +1,301 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gjkim42/gomaxprocs-injector/pkg/admission"
+	"github.com/gjkim42/gomaxprocs-injector/pkg/gomaxprocs"
+	"github.com/google/go-cmp/cmp"
+	"sigs.k8s.io/yaml"
+)
+
+const fullConfiguration = `
+apiVersion: config.gomaxprocs-injector.gjkim42.io/v1alpha1
+kind: GOMAXPROCSInjectorConfiguration
+injectionMode: opt-in
+injectAnnotationKey: example.com/gomaxprocs
+mode: report-only
+initContainers: skip
+decisionWarnings: true
+gomaxprocs:
+  enabled: false
+  enforce: true
+  strategy: floor-tolerance
+  strategyTolerance: 100
+  min: 2
+  max: 16
+  requestFallback:
+    enabled: true
+    burstMultiplier: 1.5
+    min: 1
+    max: 4
+gomemlimit:
+  enabled: true
+  percent: 80
+envFrom:
+  resolve: true
+  errorPolicy: inject
+validation:
+  action: deny
+workloadKinds:
+- Deployment
+- CronJob
+customResources:
+- group: argoproj.io
+  version: v1alpha1
+  kind: Rollout
+  podSpecPaths:
+  - spec.template.spec
+`
+
+func TestParse(t *testing.T) {
+	config, err := Parse([]byte(fullConfiguration))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := admission.Config{
+		InjectionMode:       admission.InjectionModeOptIn,
+		InjectAnnotationKey: "example.com/gomaxprocs",
+		Mode:                admission.ModeReportOnly,
+		GOMAXPROCSEnabled:   false,
+		Min:                 2,
+		Max:                 16,
+		Enforce:             true,
+		RequestFallback: admission.RequestFallbackConfig{
+			Enabled:         true,
+			BurstMultiplier: 1.5,
+			Min:             1,
+			Max:             4,
+		},
+		GOMEMLIMIT: admission.GOMEMLIMITConfig{
+			Enabled: true,
+			Percent: 80,
+		},
+		InitContainers:     admission.InitContainerPolicySkip,
+		DecisionWarnings:   true,
+		ResolveEnvFrom:     true,
+		EnvFromErrorPolicy: admission.EnvFromErrorPolicyInject,
+		ValidationAction:   admission.ValidationActionDeny,
+		WorkloadKinds:      []string{"Deployment", "CronJob"},
+		CustomResources: []admission.CustomResource{
+			{
+				Group:        "argoproj.io",
+				Version:      "v1alpha1",
+				Kind:         "Rollout",
+				PodSpecPaths: []string{"spec.template.spec"},
+			},
+		},
+	}
+	if config.Strategy.Name() != gomaxprocs.StrategyFloorTolerance || config.Strategy.Compute(1900) != 2 {
+		t.Errorf("unexpected strategy %s", config.Strategy.Name())
+	}
+	if config.Version == "" {
+		t.Errorf("expected version to be set")
+	}
+	config.Strategy = nil
+	config.Version = ""
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("expected %+v, got %+v", expected, config)
+	}
+}
+
+func TestParseDefaults(t *testing.T) {
+	config, err := Parse([]byte(`
+apiVersion: config.gomaxprocs-injector.gjkim42.io/v1alpha1
+kind: GOMAXPROCSInjectorConfiguration
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := admission.DefaultConfig()
+	if config.Strategy.Name() != expected.Strategy.Name() {
+		t.Errorf("expected strategy %s, got %s", expected.Strategy.Name(), config.Strategy.Name())
+	}
+	config.Strategy, expected.Strategy = nil, nil
+	config.Version = ""
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("expected %+v, got %+v", expected, config)
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	config, err := Parse([]byte(fullConfiguration))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every field is set in the full configuration, so converting it back
+	// yields the file.
+	var expected Configuration
+	if err := yaml.UnmarshalStrict([]byte(fullConfiguration), &expected); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(expected, FromConfig(config)); diff != "" {
+		t.Errorf("unexpected configuration (-want +got):\n%s", diff)
+	}
+
+	defaults, err := yaml.Marshal(FromConfig(admission.DefaultConfig()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := Parse(defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedDefaults := admission.DefaultConfig()
+	if parsed.Strategy.Name() != expectedDefaults.Strategy.Name() {
+		t.Errorf("expected strategy %s, got %s", expectedDefaults.Strategy.Name(), parsed.Strategy.Name())
+	}
+	parsed.Strategy, expectedDefaults.Strategy = nil, nil
+	parsed.Version = ""
+	if !reflect.DeepEqual(parsed, expectedDefaults) {
+		t.Errorf("expected %+v, got %+v", expectedDefaults, parsed)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	testCases := []struct {
+		desc string
+		data string
+	}{
+		{
+			desc: "wrong kind",
+			data: `
+apiVersion: config.gomaxprocs-injector.gjkim42.io/v1alpha1
+kind: Something
+`,
+		},
+		{
+			desc: "unknown field",
+			data: `
+apiVersion: config.gomaxprocs-injector.gjkim42.io/v1alpha1
+kind: GOMAXPROCSInjectorConfiguration
+gomaxprocs:
+  rounding: ceil
+`,
+		},
+		{
+			desc: "unknown strategy",
+			data: `
+apiVersion: config.gomaxprocs-injector.gjkim42.io/v1alpha1
+kind: GOMAXPROCSInjectorConfiguration
+gomaxprocs:
+  strategy: unknown
+`,
+		},
+		{
+			desc: "invalid bounds",
+			data: `
+apiVersion: config.gomaxprocs-injector.gjkim42.io/v1alpha1
+kind: GOMAXPROCSInjectorConfiguration
+gomaxprocs:
+  min: 4
+  max: 2
+`,
+		},
+		{
+			desc: "invalid injection mode",
+			data: `
+apiVersion: config.gomaxprocs-injector.gjkim42.io/v1alpha1
+kind: GOMAXPROCSInjectorConfiguration
+injectionMode: sometimes
+`,
+		},
+		{
+			desc: "invalid inject annotation key",
+			data: `
+apiVersion: config.gomaxprocs-injector.gjkim42.io/v1alpha1
+kind: GOMAXPROCSInjectorConfiguration
+injectAnnotationKey: "not a key"
+`,
+		},
+		{
+			desc: "invalid mode",
+			data: `
+apiVersion: config.gomaxprocs-injector.gjkim42.io/v1alpha1
+kind: GOMAXPROCSInjectorConfiguration
+mode: dry-run
+`,
+		},
+		{
+			desc: "unsupported workload kind",
+			data: `
+apiVersion: config.gomaxprocs-injector.gjkim42.io/v1alpha1
+kind: GOMAXPROCSInjectorConfiguration
+workloadKinds:
+- ReplicaSet
+`,
+		},
+		{
+			desc: "invalid pod spec path",
+			data: `
+apiVersion: config.gomaxprocs-injector.gjkim42.io/v1alpha1
+kind: GOMAXPROCSInjectorConfiguration
+customResources:
+- version: v1
+  kind: Example
+  podSpecPaths:
+  - spec..spec
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			if _, err := Parse([]byte(tc.data)); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeFile := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeFile(fullConfiguration)
+	current, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updates := make(chan admission.Config, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Watch(ctx, path, 10*time.Millisecond, current, func(config admission.Config) {
+		updates <- config
+	})
+
+	writeFile("invalid: [")
+	select {
+	case config := <-updates:
+		t.Fatalf("expected invalid configuration to be rejected, got %+v", config)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	writeFile(`
+apiVersion: config.gomaxprocs-injector.gjkim42.io/v1alpha1
+kind: GOMAXPROCSInjectorConfiguration
+gomaxprocs:
+  strategy: ceil
+`)
+	select {
+	case config := <-updates:
+		if config.Strategy.Name() != gomaxprocs.StrategyCeil {
+			t.Errorf("expected strategy %s, got %s", gomaxprocs.StrategyCeil, config.Strategy.Name())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the configuration to be reloaded")
+	}
+}
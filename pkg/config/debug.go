@@ -0,0 +1,42 @@
+package config
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gjkim42/gomaxprocs-injector/pkg/admission"
+	"k8s.io/klog/v2"
+)
+
+// effectiveConfiguration is the policy in effect, as served by Handler.
+type effectiveConfiguration struct {
+	// Version identifies the configuration file the policy was loaded from.
+	Version string `json:"version,omitempty"`
+	// Namespace is the namespace whose annotations were applied, if any.
+	Namespace string `json:"namespace,omitempty"`
+	// Warnings report the annotations of the namespace that were ignored.
+	Warnings      []string      `json:"warnings,omitempty"`
+	Configuration Configuration `json:"configuration"`
+}
+
+// Handler serves the policy returned by effective as a configuration file in
+// JSON. The namespace query parameter is passed to effective, so that the
+// policy for pods in a namespace can be inspected.
+func Handler(effective func(namespace string) (admission.Config, []string)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		namespace := r.URL.Query().Get("namespace")
+		config, warnings := effective(namespace)
+
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(effectiveConfiguration{
+			Version:       config.Version,
+			Namespace:     namespace,
+			Warnings:      warnings,
+			Configuration: FromConfig(config),
+		}); err != nil {
+			klog.ErrorS(err, "Failed to write configuration")
+		}
+	})
+}
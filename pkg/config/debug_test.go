@@ -0,0 +1,38 @@
+package config
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gjkim42/gomaxprocs-injector/pkg/admission"
+)
+
+func TestHandler(t *testing.T) {
+	var requested string
+	h := Handler(func(namespace string) (admission.Config, []string) {
+		requested = namespace
+		config := admission.DefaultConfig()
+		config.Version = "abc"
+		config.Min = 2
+		return config, []string{"namespace staging: ignoring invalid annotation"}
+	})
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/debug/config?namespace=staging", nil))
+	if requested != "staging" {
+		t.Errorf("expected the policy of namespace staging, got %q", requested)
+	}
+
+	var got effectiveConfiguration
+	if err := json.Unmarshal(res.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != "abc" || got.Namespace != "staging" || len(got.Warnings) != 1 {
+		t.Errorf("unexpected response %+v", got)
+	}
+	if got.Configuration.GOMAXPROCS.Min != 2 || got.Configuration.GOMAXPROCS.Strategy != "floor" {
+		t.Errorf("unexpected configuration %+v", got.Configuration)
+	}
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// APIVersion is the apiVersion of the configuration file.
+	APIVersion = "config.gomaxprocs-injector.gjkim42.io/v1alpha1"
+	// Kind is the kind of the configuration file.
+	Kind = "GOMAXPROCSInjectorConfiguration"
+)
+
+// Configuration is the configuration file of the gomaxprocs-injector. Fields
+// that are not set take their default values.
+type Configuration struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// InjectionMode is "opt-out" (default) or "opt-in".
+	InjectionMode string `json:"injectionMode,omitempty"`
+	// InjectAnnotationKey is the annotation, or namespace label, that
+	// enables or disables injection. Defaults to "gomaxprocs-injector/inject".
+	InjectAnnotationKey string `json:"injectAnnotationKey,omitempty"`
+	// Mode is "mutate" (default) or "report-only".
+	Mode string `json:"mode,omitempty"`
+	// InitContainers is "inject" (default) or "skip".
+	InitContainers string `json:"initContainers,omitempty"`
+	// GOMAXPROCS configures GOMAXPROCS injection.
+	GOMAXPROCS GOMAXPROCSConfiguration `json:"gomaxprocs,omitempty"`
+	// GOMEMLIMIT configures GOMEMLIMIT injection.
+	GOMEMLIMIT GOMEMLIMITConfiguration `json:"gomemlimit,omitempty"`
+	// DecisionWarnings reports every decision taken for a container as an
+	// admission warning.
+	DecisionWarnings bool `json:"decisionWarnings,omitempty"`
+	// EnvFrom configures how variables set through envFrom are handled.
+	EnvFrom EnvFromConfiguration `json:"envFrom,omitempty"`
+	// Validation configures the validating webhook.
+	Validation ValidationConfiguration `json:"validation,omitempty"`
+	// WorkloadKinds lists the kinds of workloads whose pod templates are
+	// mutated, such as "Deployment".
+	WorkloadKinds []string `json:"workloadKinds,omitempty"`
+	// CustomResources lists the kinds of custom resources whose embedded pod
+	// specs are mutated.
+	CustomResources []CustomResourceConfiguration `json:"customResources,omitempty"`
+}
+
+// GOMAXPROCSConfiguration configures GOMAXPROCS injection.
+type GOMAXPROCSConfiguration struct {
+	// Enabled turns GOMAXPROCS injection on. Defaults to true.
+	Enabled *bool `json:"enabled,omitempty"`
+	// Enforce replaces user-set values that are invalid or too large.
+	Enforce bool `json:"enforce,omitempty"`
+	// Strategy is the name of the strategy. Defaults to "floor".
+	Strategy string `json:"strategy,omitempty"`
+	// StrategyTolerance is the tolerance in millicores of the
+	// "floor-tolerance" strategy.
+	StrategyTolerance int64 `json:"strategyTolerance,omitempty"`
+	// Min is the lowest GOMAXPROCS computed from resources.
+	Min int64 `json:"min,omitempty"`
+	// Max is the highest GOMAXPROCS computed from resources.
+	Max int64 `json:"max,omitempty"`
+	// RequestFallback configures GOMAXPROCS for containers without a CPU
+	// limit.
+	RequestFallback RequestFallbackConfiguration `json:"requestFallback,omitempty"`
+}
+
+// RequestFallbackConfiguration configures GOMAXPROCS for containers without a
+// CPU limit.
+type RequestFallbackConfiguration struct {
+	// Enabled derives GOMAXPROCS from the CPU request.
+	Enabled bool `json:"enabled,omitempty"`
+	// BurstMultiplier scales the CPU request. Defaults to 1.
+	BurstMultiplier float64 `json:"burstMultiplier,omitempty"`
+	// Min is the lowest GOMAXPROCS derived from a CPU request.
+	Min int64 `json:"min,omitempty"`
+	// Max is the highest GOMAXPROCS derived from a CPU request.
+	Max int64 `json:"max,omitempty"`
+}
+
+// GOMEMLIMITConfiguration configures GOMEMLIMIT injection.
+type GOMEMLIMITConfiguration struct {
+	// Enabled turns GOMEMLIMIT injection on.
+	Enabled bool `json:"enabled,omitempty"`
+	// Percent is the percentage of the memory limit GOMEMLIMIT is set to.
+	// Defaults to 90.
+	Percent int64 `json:"percent,omitempty"`
+}
+
+// EnvFromConfiguration configures how variables set through envFrom are
+// handled.
+type EnvFromConfiguration struct {
+	// Resolve looks up the ConfigMaps and Secrets referenced by envFrom to
+	// detect variables set by the user. Turning it on takes a restart, as
+	// they are only watched if it is on at startup.
+	Resolve bool `json:"resolve,omitempty"`
+	// ErrorPolicy is "skip" (default) or "inject".
+	ErrorPolicy string `json:"errorPolicy,omitempty"`
+}
+
+// ValidationConfiguration configures the validating webhook.
+type ValidationConfiguration struct {
+	// Action is "warn" (default) or "deny".
+	Action string `json:"action,omitempty"`
+}
+
+// CustomResourceConfiguration maps a kind of custom resource to the pod specs
+// it embeds.
+type CustomResourceConfiguration struct {
+	Group   string `json:"group,omitempty"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+	// PodSpecPaths are the dot-separated paths of the pod specs in the
+	// object, such as "spec.template.spec".
+	PodSpecPaths []string `json:"podSpecPaths"`
+}
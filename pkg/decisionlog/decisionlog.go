@@ -0,0 +1,139 @@
+// Package decisionlog writes the decision log of the gomaxprocs-injector: one
+// JSON line per admission request with what was decided for the object and
+// why. The latest entries can also be kept in memory for debugging.
+package decisionlog
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+	"k8s.io/klog/v2"
+)
+
+// Entry describes an admission request and what the webhook did with it.
+type Entry struct {
+	Time         time.Time `json:"time"`
+	Webhook      string    `json:"webhook"`
+	UID          string    `json:"uid"`
+	User         string    `json:"user,omitempty"`
+	Operation    string    `json:"operation"`
+	Resource     string    `json:"resource"`
+	SubResource  string    `json:"subResource,omitempty"`
+	Namespace    string    `json:"namespace,omitempty"`
+	Name         string    `json:"name,omitempty"`
+	GenerateName string    `json:"generateName,omitempty"`
+	DryRun       bool      `json:"dryRun,omitempty"`
+	Allowed      bool      `json:"allowed"`
+	// Message is the reason the request was denied.
+	Message string `json:"message,omitempty"`
+	// Decisions are the decisions taken for the containers, as in the
+	// decisions audit annotation.
+	Decisions json.RawMessage `json:"decisions,omitempty"`
+	// Patch is the JSONPatch computed for the object. Patched tells whether
+	// it was applied, rather than only reported in report-only mode.
+	Patch    json.RawMessage `json:"patch,omitempty"`
+	Patched  bool            `json:"patched"`
+	Warnings []string        `json:"warnings,omitempty"`
+}
+
+// Sampling limits the entries written when there is a lot of traffic. In
+// every second, the first Initial entries are written, then every Thereafter-th
+// entry. An Initial of 0 disables sampling.
+type Sampling struct {
+	Initial    int
+	Thereafter int
+}
+
+// Logger writes entries as JSON lines. A nil *Logger writes nothing.
+type Logger struct {
+	sampling Sampling
+	now      func() time.Time
+
+	mu     sync.Mutex
+	w      io.Writer
+	window time.Time
+	count  int
+}
+
+// New returns a Logger that writes the sampled entries to w.
+func New(w io.Writer, sampling Sampling) *Logger {
+	return &Logger{
+		w:        w,
+		sampling: sampling,
+		now:      time.Now,
+	}
+}
+
+// NewFile returns a Logger that writes the sampled entries to the file at
+// path, or to stdout if path is "-". The file is rotated once it reaches
+// maxSizeMB megabytes, and at most maxBackups rotated files are kept.
+func NewFile(path string, maxSizeMB, maxBackups int, sampling Sampling) *Logger {
+	if path == "-" {
+		return New(os.Stdout, sampling)
+	}
+	return New(&lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    maxSizeMB,
+		MaxBackups: maxBackups,
+	}, sampling)
+}
+
+// Sampled tells whether the next entry is to be written, so that callers can
+// skip building entries that would be dropped. Each call counts as an entry.
+func (l *Logger) Sampled() bool {
+	if l == nil {
+		return false
+	}
+	if l.sampling.Initial <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Sub(l.window) >= time.Second {
+		l.window = now
+		l.count = 0
+	}
+	l.count++
+	if l.count <= l.sampling.Initial {
+		return true
+	}
+	return l.sampling.Thereafter > 0 && (l.count-l.sampling.Initial)%l.sampling.Thereafter == 0
+}
+
+// Log writes entry as a JSON line. Entries are not sampled; callers ask
+// Sampled first.
+func (l *Logger) Log(entry Entry) {
+	if l == nil {
+		return
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		klog.ErrorS(err, "Failed to marshal decision log entry", "uid", entry.UID)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(line); err != nil {
+		klog.ErrorS(err, "Failed to write decision log entry", "uid", entry.UID)
+	}
+}
+
+// Close closes the file the Logger writes to, if any.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	if c, ok := l.w.(io.Closer); ok && l.w != os.Stdout {
+		return c.Close()
+	}
+	return nil
+}
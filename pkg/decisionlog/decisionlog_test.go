@@ -0,0 +1,92 @@
+package decisionlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSampled(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		sampling Sampling
+		expected []bool
+	}{
+		{
+			desc:     "no sampling",
+			expected: []bool{true, true, true, true, true},
+		},
+		{
+			desc:     "initial only",
+			sampling: Sampling{Initial: 2},
+			expected: []bool{true, true, false, false, false},
+		},
+		{
+			desc:     "initial and thereafter",
+			sampling: Sampling{Initial: 1, Thereafter: 2},
+			expected: []bool{true, false, true, false, true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			now := time.Unix(0, 0)
+			l := New(&bytes.Buffer{}, tc.sampling)
+			l.now = func() time.Time { return now }
+
+			var sampled []bool
+			for range tc.expected {
+				sampled = append(sampled, l.Sampled())
+			}
+			if diff := cmp.Diff(tc.expected, sampled); diff != "" {
+				t.Errorf("unexpected sampling (-want +got):\n%s", diff)
+			}
+
+			// The count starts over every second.
+			now = now.Add(time.Second)
+			if !l.Sampled() {
+				t.Errorf("expected the first entry of the next second to be sampled")
+			}
+		})
+	}
+}
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, Sampling{})
+	l.Log(Entry{
+		Time:         time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		Webhook:      "mutating",
+		UID:          "1",
+		Operation:    "CREATE",
+		Resource:     "/v1, Resource=pods",
+		Namespace:    "default",
+		GenerateName: "test-",
+		Allowed:      true,
+		Decisions:    json.RawMessage(`[{"container":"app","action":"injected","reason":"cpu-limit"}]`),
+		Patch:        json.RawMessage(`[{"op":"add","path":"/spec/containers/0/env","value":[]}]`),
+		Patched:      true,
+	})
+	l.Log(Entry{UID: "2"})
+
+	expected := `{"time":"2025-01-01T00:00:00Z","webhook":"mutating","uid":"1","operation":"CREATE","resource":"/v1, Resource=pods","namespace":"default","generateName":"test-","allowed":true,` +
+		`"decisions":[{"container":"app","action":"injected","reason":"cpu-limit"}],"patch":[{"op":"add","path":"/spec/containers/0/env","value":[]}],"patched":true}` + "\n" +
+		`{"time":"0001-01-01T00:00:00Z","webhook":"","uid":"2","operation":"","resource":"","allowed":false,"patched":false}` + "\n"
+	if diff := cmp.Diff(expected, buf.String()); diff != "" {
+		t.Errorf("unexpected log (-want +got):\n%s", diff)
+	}
+}
+
+func TestNilLogger(t *testing.T) {
+	var l *Logger
+	if l.Sampled() {
+		t.Errorf("expected a nil Logger not to sample entries")
+	}
+	l.Log(Entry{})
+	if err := l.Close(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,117 @@
+package decisionlog
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+// Ring keeps the last entries in memory. A nil *Ring keeps nothing.
+type Ring struct {
+	mu      sync.Mutex
+	entries []Entry
+	// next is the index at which the next entry is added.
+	next int
+	full bool
+}
+
+// NewRing returns a Ring that keeps the last size entries.
+func NewRing(size int) *Ring {
+	return &Ring{entries: make([]Entry, size)}
+}
+
+// Add adds entry, dropping the oldest entry if the Ring is full.
+func (r *Ring) Add(entry Entry) {
+	if r == nil || len(r.entries) == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[r.next] = entry
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Filter selects entries. Empty fields match every entry.
+type Filter struct {
+	// Namespace is the namespace of the object.
+	Namespace string
+	// NamePrefix is a prefix of the name or, for objects that have no name
+	// yet, the generateName of the object.
+	NamePrefix string
+	// Limit is the maximum number of entries. 0 means no limit.
+	Limit int
+}
+
+// Matches tells whether entry is selected by f, regardless of the limit.
+func (f Filter) Matches(entry Entry) bool {
+	if f.Namespace != "" && entry.Namespace != f.Namespace {
+		return false
+	}
+	if f.NamePrefix == "" {
+		return true
+	}
+	if entry.Name != "" {
+		return strings.HasPrefix(entry.Name, f.NamePrefix)
+	}
+	return strings.HasPrefix(entry.GenerateName, f.NamePrefix)
+}
+
+// List returns the entries selected by filter, newest first.
+func (r *Ring) List(filter Filter) []Entry {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := r.next
+	if r.full {
+		n = len(r.entries)
+	}
+	entries := []Entry{}
+	for i := 1; i <= n; i++ {
+		entry := r.entries[(r.next-i+len(r.entries))%len(r.entries)]
+		if !filter.Matches(entry) {
+			continue
+		}
+		entries = append(entries, entry)
+		if filter.Limit > 0 && len(entries) == filter.Limit {
+			break
+		}
+	}
+	return entries
+}
+
+// Handler serves the entries of r as a JSON array, newest first. The
+// namespace, name and limit query parameters set the fields of the Filter,
+// name being a prefix.
+func (r *Ring) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		filter := Filter{
+			Namespace:  query.Get("namespace"),
+			NamePrefix: query.Get("name"),
+		}
+		if limit := query.Get("limit"); limit != "" {
+			var err error
+			filter.Limit, err = strconv.Atoi(limit)
+			if err != nil || filter.Limit < 0 {
+				http.Error(w, "limit must be a non-negative integer", http.StatusBadRequest)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(r.List(filter)); err != nil {
+			klog.ErrorS(err, "Failed to write recent decisions")
+		}
+	})
+}
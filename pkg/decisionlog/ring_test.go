@@ -0,0 +1,100 @@
+package decisionlog
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRing(t *testing.T) {
+	r := NewRing(3)
+	for _, entry := range []Entry{
+		{UID: "1", Namespace: "default", Name: "web-1"},
+		{UID: "2", Namespace: "default", GenerateName: "web-"},
+		{UID: "3", Namespace: "other", GenerateName: "web-"},
+		{UID: "4", Namespace: "default", Name: "db-0"},
+	} {
+		r.Add(entry)
+	}
+
+	testCases := []struct {
+		desc     string
+		filter   Filter
+		expected []string
+	}{
+		{
+			desc:     "all, newest first, oldest dropped",
+			expected: []string{"4", "3", "2"},
+		},
+		{
+			desc:     "namespace",
+			filter:   Filter{Namespace: "default"},
+			expected: []string{"4", "2"},
+		},
+		{
+			desc:     "name prefix matching the generateName",
+			filter:   Filter{NamePrefix: "web"},
+			expected: []string{"3", "2"},
+		},
+		{
+			desc:     "namespace and name prefix",
+			filter:   Filter{Namespace: "default", NamePrefix: "db"},
+			expected: []string{"4"},
+		},
+		{
+			desc:     "limit",
+			filter:   Filter{Limit: 1},
+			expected: []string{"4"},
+		},
+		{
+			desc:     "no match",
+			filter:   Filter{Namespace: "unknown"},
+			expected: []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			uids := []string{}
+			for _, entry := range r.List(tc.filter) {
+				uids = append(uids, entry.UID)
+			}
+			if diff := cmp.Diff(tc.expected, uids); diff != "" {
+				t.Errorf("unexpected entries (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRingHandler(t *testing.T) {
+	r := NewRing(2)
+	r.Add(Entry{UID: "1", Namespace: "default", Name: "web-1"})
+	r.Add(Entry{UID: "2", Namespace: "other", Name: "web-2"})
+
+	res := httptest.NewRecorder()
+	r.Handler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/debug/decisions?namespace=default&name=web", nil))
+	var entries []Entry
+	if err := json.Unmarshal(res.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].UID != "1" {
+		t.Errorf("expected entry 1, got %+v", entries)
+	}
+
+	res = httptest.NewRecorder()
+	r.Handler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/debug/decisions?limit=many", nil))
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("expected %d for an invalid limit, got %d", http.StatusBadRequest, res.Code)
+	}
+}
+
+func TestNilRing(t *testing.T) {
+	var r *Ring
+	r.Add(Entry{})
+	if entries := r.List(Filter{}); entries != nil {
+		t.Errorf("expected no entries, got %v", entries)
+	}
+}
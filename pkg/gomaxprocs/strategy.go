@@ -0,0 +1,143 @@
+// Package gomaxprocs computes GOMAXPROCS values from CPU quotas.
+package gomaxprocs
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// StrategyFloor rounds the CPU quota down to the nearest core.
+	StrategyFloor = "floor"
+	// StrategyCeil rounds the CPU quota up to the nearest core.
+	StrategyCeil = "ceil"
+	// StrategyRound rounds the CPU quota to the nearest core, rounding
+	// half-way values up.
+	StrategyRound = "round"
+	// StrategyFloorTolerance rounds the CPU quota down unless it is within a
+	// configured number of millicores of the next core.
+	StrategyFloorTolerance = "floor-tolerance"
+	// StrategyGoRuntime matches the container-aware default of Go 1.25 and
+	// newer, which rounds the quota up and never goes below 2.
+	StrategyGoRuntime = "go-runtime"
+)
+
+// Strategies lists the names of all built-in strategies.
+var Strategies = []string{
+	StrategyFloor,
+	StrategyCeil,
+	StrategyRound,
+	StrategyFloorTolerance,
+	StrategyGoRuntime,
+}
+
+// Strategy computes GOMAXPROCS from a CPU quota.
+type Strategy interface {
+	// Name returns the name of the strategy.
+	Name() string
+	// Compute returns GOMAXPROCS for a CPU quota given in millicores. The
+	// result is always at least 1.
+	Compute(milliCPU int64) int64
+}
+
+// New returns the built-in strategy with the given name. tolerance is the
+// number of millicores used by StrategyFloorTolerance and is ignored by the
+// other strategies.
+func New(name string, tolerance int64) (Strategy, error) {
+	switch name {
+	case StrategyFloor:
+		return Floor(), nil
+	case StrategyCeil:
+		return Ceil(), nil
+	case StrategyRound:
+		return Round(), nil
+	case StrategyFloorTolerance:
+		return FloorTolerance(tolerance)
+	case StrategyGoRuntime:
+		return GoRuntime(), nil
+	default:
+		return nil, fmt.Errorf("unknown strategy %q, must be one of %s", name, strings.Join(Strategies, ", "))
+	}
+}
+
+// Floor returns a strategy that computes max(1, floor(quota)).
+func Floor() Strategy {
+	return floorStrategy{}
+}
+
+// Ceil returns a strategy that computes max(1, ceil(quota)).
+func Ceil() Strategy {
+	return ceilStrategy{}
+}
+
+// Round returns a strategy that computes max(1, round-half-up(quota)).
+func Round() Strategy {
+	return roundStrategy{}
+}
+
+// FloorTolerance returns a strategy that rounds the quota down unless it is
+// within tolerance millicores of the next core, in which case it rounds up.
+func FloorTolerance(tolerance int64) (Strategy, error) {
+	if tolerance < 0 || tolerance >= 1000 {
+		return nil, fmt.Errorf("tolerance must be between 0 and 999 millicores, got %d", tolerance)
+	}
+	return floorToleranceStrategy{tolerance: tolerance}, nil
+}
+
+// GoRuntime returns a strategy that computes max(2, ceil(quota)), like the
+// Go runtime does for containers with a CPU limit since Go 1.25.
+func GoRuntime() Strategy {
+	return goRuntimeStrategy{}
+}
+
+type floorStrategy struct{}
+
+func (floorStrategy) Name() string { return StrategyFloor }
+
+func (floorStrategy) Compute(milliCPU int64) int64 {
+	return atLeast(1, milliCPU/1000)
+}
+
+type ceilStrategy struct{}
+
+func (ceilStrategy) Name() string { return StrategyCeil }
+
+func (ceilStrategy) Compute(milliCPU int64) int64 {
+	return atLeast(1, (milliCPU+999)/1000)
+}
+
+type roundStrategy struct{}
+
+func (roundStrategy) Name() string { return StrategyRound }
+
+func (roundStrategy) Compute(milliCPU int64) int64 {
+	return atLeast(1, (milliCPU+500)/1000)
+}
+
+type floorToleranceStrategy struct {
+	tolerance int64
+}
+
+func (floorToleranceStrategy) Name() string { return StrategyFloorTolerance }
+
+func (s floorToleranceStrategy) Compute(milliCPU int64) int64 {
+	return atLeast(1, (milliCPU+s.tolerance)/1000)
+}
+
+// Tolerance returns the tolerance of the strategy in millicores.
+func (s floorToleranceStrategy) Tolerance() int64 { return s.tolerance }
+
+type goRuntimeStrategy struct{}
+
+func (goRuntimeStrategy) Name() string { return StrategyGoRuntime }
+
+func (goRuntimeStrategy) Compute(milliCPU int64) int64 {
+	return atLeast(2, (milliCPU+999)/1000)
+}
+
+func atLeast(min, v int64) int64 {
+	if v < min {
+		return min
+	}
+	return v
+}
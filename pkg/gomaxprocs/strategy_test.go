@@ -0,0 +1,70 @@
+package gomaxprocs
+
+import (
+	"testing"
+)
+
+func TestStrategies(t *testing.T) {
+	floorTolerance, err := FloorTolerance(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		milliCPU int64
+
+		floor          int64
+		ceil           int64
+		round          int64
+		floorTolerance int64
+		goRuntime      int64
+	}{
+		{milliCPU: 0, floor: 1, ceil: 1, round: 1, floorTolerance: 1, goRuntime: 2},
+		{milliCPU: 100, floor: 1, ceil: 1, round: 1, floorTolerance: 1, goRuntime: 2},
+		{milliCPU: 1000, floor: 1, ceil: 1, round: 1, floorTolerance: 1, goRuntime: 2},
+		{milliCPU: 1499, floor: 1, ceil: 2, round: 1, floorTolerance: 1, goRuntime: 2},
+		{milliCPU: 1500, floor: 1, ceil: 2, round: 2, floorTolerance: 1, goRuntime: 2},
+		{milliCPU: 1899, floor: 1, ceil: 2, round: 2, floorTolerance: 1, goRuntime: 2},
+		{milliCPU: 1900, floor: 1, ceil: 2, round: 2, floorTolerance: 2, goRuntime: 2},
+		{milliCPU: 2000, floor: 2, ceil: 2, round: 2, floorTolerance: 2, goRuntime: 2},
+		{milliCPU: 2001, floor: 2, ceil: 3, round: 2, floorTolerance: 2, goRuntime: 3},
+		{milliCPU: 7950, floor: 7, ceil: 8, round: 8, floorTolerance: 8, goRuntime: 8},
+	}
+
+	for _, tc := range testCases {
+		for _, c := range []struct {
+			strategy Strategy
+			expected int64
+		}{
+			{Floor(), tc.floor},
+			{Ceil(), tc.ceil},
+			{Round(), tc.round},
+			{floorTolerance, tc.floorTolerance},
+			{GoRuntime(), tc.goRuntime},
+		} {
+			if got := c.strategy.Compute(tc.milliCPU); got != c.expected {
+				t.Errorf("%s(%dm): expected %d, got %d", c.strategy.Name(), tc.milliCPU, c.expected, got)
+			}
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, name := range Strategies {
+		s, err := New(name, 100)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+			continue
+		}
+		if s.Name() != name {
+			t.Errorf("expected name %s, got %s", name, s.Name())
+		}
+	}
+
+	if _, err := New("unknown", 0); err == nil {
+		t.Errorf("expected error for unknown strategy")
+	}
+	if _, err := New(StrategyFloorTolerance, 1000); err == nil {
+		t.Errorf("expected error for out of range tolerance")
+	}
+}
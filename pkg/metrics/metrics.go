@@ -0,0 +1,127 @@
+// Package metrics defines the Prometheus metrics of the gomaxprocs-injector.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gomaxprocs_injector"
+
+// Stages of handling an admission request.
+const (
+	// StageDecode decodes the AdmissionReview.
+	StageDecode = "decode"
+	// StageCompute decides what to do with the object, including StageDiff.
+	StageCompute = "compute"
+	// StageDiff computes the JSONPatch.
+	StageDiff = "diff"
+	// StageEncode encodes the response.
+	StageEncode = "encode"
+)
+
+// Results of an admission request.
+const (
+	// ResultAllowed allows the object unchanged.
+	ResultAllowed = "allowed"
+	// ResultPatched allows the object with a patch.
+	ResultPatched = "patched"
+	// ResultDenied denies the object.
+	ResultDenied = "denied"
+	// ResultError means the request could not be decoded or answered.
+	ResultError = "error"
+)
+
+// Metrics holds the collectors of the gomaxprocs-injector. A nil *Metrics
+// records nothing.
+type Metrics struct {
+	requests          *prometheus.CounterVec
+	stageDuration     *prometheus.HistogramVec
+	decisions         *prometheus.CounterVec
+	patchSize         prometheus.Histogram
+	certificateExpiry prometheus.Gauge
+}
+
+// New creates the collectors and registers them with registerer.
+func New(registerer prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "admission_requests_total",
+			Help:      "Number of admission requests by webhook, AdmissionReview version, result and namespace.",
+		}, []string{"webhook", "api_version", "result", "namespace"}),
+		stageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "admission_stage_duration_seconds",
+			Help:      "Time spent in each stage of handling an admission request. The compute stage includes the diff stage.",
+			Buckets:   []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1},
+		}, []string{"webhook", "stage"}),
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "container_decisions_total",
+			Help:      "Number of decisions taken for containers by variable, action, reason and mode.",
+		}, []string{"variable", "action", "reason", "mode"}),
+		patchSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "patch_size_bytes",
+			Help:      "Size of the JSONPatches computed for admitted objects, including those not applied in report-only mode.",
+			Buckets:   prometheus.ExponentialBuckets(64, 2, 10),
+		}),
+		certificateExpiry: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "certificate_expiry_timestamp_seconds",
+			Help:      "Time at which the serving certificate expires, in seconds since the Unix epoch.",
+		}),
+	}
+	registerer.MustRegister(m.requests, m.stageDuration, m.decisions, m.patchSize, m.certificateExpiry)
+	return m
+}
+
+// ObserveRequest counts an admission request.
+func (m *Metrics) ObserveRequest(webhook, apiVersion, result, namespace string) {
+	if m == nil {
+		return
+	}
+	m.requests.WithLabelValues(webhook, apiVersion, result, namespace).Inc()
+}
+
+// ObserveStage records the time spent in a stage since start.
+func (m *Metrics) ObserveStage(webhook, stage string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.stageDuration.WithLabelValues(webhook, stage).Observe(time.Since(start).Seconds())
+}
+
+// ObserveDecision counts a decision taken for a container.
+func (m *Metrics) ObserveDecision(variable, action, reason, mode string) {
+	if m == nil {
+		return
+	}
+	m.decisions.WithLabelValues(variable, action, reason, mode).Inc()
+}
+
+// ObservePatchSize records the size of a JSONPatch in bytes.
+func (m *Metrics) ObservePatchSize(size int) {
+	if m == nil {
+		return
+	}
+	m.patchSize.Observe(float64(size))
+}
+
+// SetCertificateExpiry records when the serving certificate expires.
+func (m *Metrics) SetCertificateExpiry(notAfter time.Time) {
+	if m == nil {
+		return
+	}
+	m.certificateExpiry.Set(float64(notAfter.Unix()))
+}
+
+// Handler serves the metrics gathered by gatherer in the Prometheus text
+// format.
+func Handler(gatherer prometheus.Gatherer) http.Handler {
+	return promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m := New(registry)
+
+	m.ObserveRequest("mutating", "v1", ResultPatched, "default")
+	m.ObserveRequest("mutating", "v1", ResultPatched, "default")
+	m.ObserveRequest("mutating", "v1beta1", ResultDenied, "other")
+	m.ObserveStage("mutating", StageDecode, time.Now())
+	m.ObserveDecision("GOMAXPROCS", "injected", "cpu-limit", "mutate")
+	m.ObservePatchSize(100)
+	m.SetCertificateExpiry(time.Unix(1700000000, 0))
+
+	if got := testutil.ToFloat64(m.requests.WithLabelValues("mutating", "v1", ResultPatched, "default")); got != 2 {
+		t.Errorf("expected 2 requests, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.decisions.WithLabelValues("GOMAXPROCS", "injected", "cpu-limit", "mutate")); got != 1 {
+		t.Errorf("expected 1 decision, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.certificateExpiry); got != 1700000000 {
+		t.Errorf("expected certificate expiry 1700000000, got %v", got)
+	}
+
+	res := httptest.NewRecorder()
+	Handler(registry).ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		"gomaxprocs_injector_admission_requests_total",
+		"gomaxprocs_injector_admission_stage_duration_seconds_bucket",
+		"gomaxprocs_injector_container_decisions_total",
+		"gomaxprocs_injector_patch_size_bytes_bucket",
+		"gomaxprocs_injector_certificate_expiry_timestamp_seconds",
+	} {
+		if !strings.Contains(string(body), name) {
+			t.Errorf("expected %s in the output", name)
+		}
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.ObserveRequest("mutating", "v1", ResultAllowed, "default")
+	m.ObserveStage("mutating", StageDecode, time.Now())
+	m.ObserveDecision("GOMAXPROCS", "injected", "cpu-limit", "mutate")
+	m.ObservePatchSize(100)
+	m.SetCertificateExpiry(time.Now())
+}
@@ -0,0 +1,114 @@
+// Package policy resolves the GOMAXPROCSPolicy and ClusterGOMAXPROCSPolicy
+// custom resources that apply to a container and reports on their use.
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gjkim42/gomaxprocs-injector/pkg/apis/gomaxprocs/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Lister lists policies from a cache.
+type Lister interface {
+	// Policies returns the GOMAXPROCSPolicies in the namespace, or in all
+	// namespaces if namespace is metav1.NamespaceAll.
+	Policies(namespace string) []*v1alpha1.GOMAXPROCSPolicy
+	// ClusterPolicies returns all ClusterGOMAXPROCSPolicies.
+	ClusterPolicies() []*v1alpha1.ClusterGOMAXPROCSPolicy
+}
+
+// NewLister returns a Lister backed by indexers holding typed policies, such
+// as the ones of the informers returned by NewInformers.
+func NewLister(policies, clusterPolicies cache.Indexer) Lister {
+	return &indexerLister{
+		policies:        policies,
+		clusterPolicies: clusterPolicies,
+	}
+}
+
+type indexerLister struct {
+	policies        cache.Indexer
+	clusterPolicies cache.Indexer
+}
+
+func (l *indexerLister) Policies(namespace string) []*v1alpha1.GOMAXPROCSPolicy {
+	objs := l.policies.List()
+	if namespace != metav1.NamespaceAll {
+		var err error
+		if objs, err = l.policies.ByIndex(cache.NamespaceIndex, namespace); err != nil {
+			return nil
+		}
+	}
+
+	policies := make([]*v1alpha1.GOMAXPROCSPolicy, 0, len(objs))
+	for _, obj := range objs {
+		if policy, ok := obj.(*v1alpha1.GOMAXPROCSPolicy); ok {
+			policies = append(policies, policy)
+		}
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		if policies[i].Namespace != policies[j].Namespace {
+			return policies[i].Namespace < policies[j].Namespace
+		}
+		return policies[i].Name < policies[j].Name
+	})
+	return policies
+}
+
+func (l *indexerLister) ClusterPolicies() []*v1alpha1.ClusterGOMAXPROCSPolicy {
+	objs := l.clusterPolicies.List()
+
+	policies := make([]*v1alpha1.ClusterGOMAXPROCSPolicy, 0, len(objs))
+	for _, obj := range objs {
+		if policy, ok := obj.(*v1alpha1.ClusterGOMAXPROCSPolicy); ok {
+			policies = append(policies, policy)
+		}
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Name < policies[j].Name })
+	return policies
+}
+
+// NewInformers returns informers for GOMAXPROCSPolicies and
+// ClusterGOMAXPROCSPolicies. There is no generated clientset for the custom
+// resources, so the informers watch them through the dynamic client and
+// convert them into typed objects before they are stored.
+func NewInformers(client dynamic.Interface, resync time.Duration) (policies, clusterPolicies cache.SharedIndexInformer, err error) {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, resync)
+
+	policies = factory.ForResource(v1alpha1.GOMAXPROCSPolicyResource).Informer()
+	if err := policies.SetTransform(toTyped(v1alpha1.GOMAXPROCSPolicyResource, func() interface{} { return &v1alpha1.GOMAXPROCSPolicy{} })); err != nil {
+		return nil, nil, err
+	}
+	clusterPolicies = factory.ForResource(v1alpha1.ClusterGOMAXPROCSPolicyResource).Informer()
+	if err := clusterPolicies.SetTransform(toTyped(v1alpha1.ClusterGOMAXPROCSPolicyResource, func() interface{} { return &v1alpha1.ClusterGOMAXPROCSPolicy{} })); err != nil {
+		return nil, nil, err
+	}
+
+	return policies, clusterPolicies, nil
+}
+
+// toTyped returns an informer transform that converts unstructured objects of
+// resource into the objects returned by newObj.
+func toTyped(resource schema.GroupVersionResource, newObj func() interface{}) cache.TransformFunc {
+	return func(obj interface{}) (interface{}, error) {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return obj, nil
+		}
+
+		typed := newObj()
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, typed); err != nil {
+			return nil, fmt.Errorf("failed to convert %s %s: %w", resource.Resource, u.GetName(), err)
+		}
+		return typed, nil
+	}
+}
@@ -0,0 +1,162 @@
+package policy
+
+import (
+	"path"
+
+	"github.com/gjkim42/gomaxprocs-injector/pkg/apis/gomaxprocs/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+// Ref identifies a policy. Namespace is empty for ClusterGOMAXPROCSPolicies.
+type Ref struct {
+	Namespace string
+	Name      string
+}
+
+func (r Ref) String() string {
+	if r.Namespace == "" {
+		return "ClusterGOMAXPROCSPolicy/" + r.Name
+	}
+	return "GOMAXPROCSPolicy/" + r.Namespace + "/" + r.Name
+}
+
+// Match holds the policies that apply to a container. At most one policy of
+// each scope applies: the one with the highest priority, or the first by name
+// if several share the highest priority, in which case they conflict.
+type Match struct {
+	// Cluster is the ClusterGOMAXPROCSPolicy that applies, if any.
+	Cluster *v1alpha1.ClusterGOMAXPROCSPolicy
+	// Namespaced is the GOMAXPROCSPolicy that applies, if any. Its settings
+	// take precedence over the ones of Cluster.
+	Namespaced *v1alpha1.GOMAXPROCSPolicy
+	// Conflicts lists groups of policies that matched with the same scope
+	// and priority. The first policy of each group is the one applied.
+	Conflicts [][]Ref
+}
+
+// Applied returns the policies that apply.
+func (m Match) Applied() []Ref {
+	var refs []Ref
+	if m.Cluster != nil {
+		refs = append(refs, Ref{Name: m.Cluster.Name})
+	}
+	if m.Namespaced != nil {
+		refs = append(refs, Ref{Namespace: m.Namespaced.Namespace, Name: m.Namespaced.Name})
+	}
+	return refs
+}
+
+// Resolve returns the policies that apply to a container of the pod.
+// namespace may be nil if it is unknown, in which case cluster policies with
+// a namespace selector do not match.
+func Resolve(lister Lister, pod *corev1.Pod, namespace *corev1.Namespace, container *corev1.Container) Match {
+	var match Match
+
+	var clusterCandidates []candidate
+	for _, policy := range lister.ClusterPolicies() {
+		if namespaceSelector := policy.Spec.NamespaceSelector; namespaceSelector != nil && !isEmptySelector(namespaceSelector) {
+			if namespace == nil || !selects(namespaceSelector, namespace.Labels, policy.Name) {
+				continue
+			}
+		}
+		if matchesPodAndContainer(&policy.Spec.GOMAXPROCSPolicySpec, pod, container, policy.Name) {
+			clusterCandidates = append(clusterCandidates, candidate{Ref{Name: policy.Name}, policy.Spec.Priority, policy})
+		}
+	}
+	if winner, conflict := pick(clusterCandidates); winner != nil {
+		match.Cluster = winner.(*v1alpha1.ClusterGOMAXPROCSPolicy)
+		if conflict != nil {
+			match.Conflicts = append(match.Conflicts, conflict)
+		}
+	}
+
+	var candidates []candidate
+	for _, policy := range lister.Policies(pod.Namespace) {
+		if matchesPodAndContainer(&policy.Spec, pod, container, policy.Name) {
+			candidates = append(candidates, candidate{Ref{Namespace: policy.Namespace, Name: policy.Name}, policy.Spec.Priority, policy})
+		}
+	}
+	if winner, conflict := pick(candidates); winner != nil {
+		match.Namespaced = winner.(*v1alpha1.GOMAXPROCSPolicy)
+		if conflict != nil {
+			match.Conflicts = append(match.Conflicts, conflict)
+		}
+	}
+
+	return match
+}
+
+type candidate struct {
+	ref      Ref
+	priority int32
+	policy   interface{}
+}
+
+// pick returns the policy with the highest priority. candidates are sorted by
+// name, so the first one wins a tie. If there is a tie, the tied policies are
+// returned as a conflict.
+func pick(candidates []candidate) (interface{}, []Ref) {
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	winner := candidates[0]
+	for _, c := range candidates[1:] {
+		if c.priority > winner.priority {
+			winner = c
+		}
+	}
+
+	var tied []Ref
+	for _, c := range candidates {
+		if c.priority == winner.priority {
+			tied = append(tied, c.ref)
+		}
+	}
+	if len(tied) < 2 {
+		return winner.policy, nil
+	}
+	return winner.policy, tied
+}
+
+func matchesPodAndContainer(spec *v1alpha1.GOMAXPROCSPolicySpec, pod *corev1.Pod, container *corev1.Container, name string) bool {
+	if spec.PodSelector != nil && !isEmptySelector(spec.PodSelector) && !selects(spec.PodSelector, pod.Labels, name) {
+		return false
+	}
+
+	if len(spec.Containers) == 0 {
+		return true
+	}
+	for _, matcher := range spec.Containers {
+		if matchesPattern(matcher.Name, container.Name) && matchesPattern(matcher.Image, container.Image) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesPattern(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, err := path.Match(pattern, s)
+	return err == nil && matched
+}
+
+func isEmptySelector(selector *metav1.LabelSelector) bool {
+	return len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0
+}
+
+// selects reports whether the selector matches the labels. An invalid
+// selector matches nothing.
+func selects(selector *metav1.LabelSelector, set map[string]string, policyName string) bool {
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		klog.ErrorS(err, "Ignoring policy with invalid selector", "policy", policyName)
+		return false
+	}
+	return s.Matches(labels.Set(set))
+}
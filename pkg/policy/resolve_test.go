@@ -0,0 +1,204 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gjkim42/gomaxprocs-injector/pkg/apis/gomaxprocs/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestLister(t *testing.T, objs ...interface{}) Lister {
+	t.Helper()
+
+	policies := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	clusterPolicies := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, obj := range objs {
+		indexer := policies
+		if _, ok := obj.(*v1alpha1.ClusterGOMAXPROCSPolicy); ok {
+			indexer = clusterPolicies
+		}
+		if err := indexer.Add(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewLister(policies, clusterPolicies)
+}
+
+func namespacedPolicy(namespace, name string, priority int32, mutate func(*v1alpha1.GOMAXPROCSPolicySpec)) *v1alpha1.GOMAXPROCSPolicy {
+	p := &v1alpha1.GOMAXPROCSPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       v1alpha1.GOMAXPROCSPolicySpec{Priority: priority},
+	}
+	if mutate != nil {
+		mutate(&p.Spec)
+	}
+	return p
+}
+
+func clusterPolicy(name string, priority int32, mutate func(*v1alpha1.ClusterGOMAXPROCSPolicySpec)) *v1alpha1.ClusterGOMAXPROCSPolicy {
+	p := &v1alpha1.ClusterGOMAXPROCSPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+	}
+	p.Spec.Priority = priority
+	if mutate != nil {
+		mutate(&p.Spec)
+	}
+	return p
+}
+
+func TestResolve(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "pod",
+			Labels:    map[string]string{"app": "web"},
+		},
+	}
+	container := &corev1.Container{Name: "app", Image: "registry.example.com/web:v1"}
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{"team": "a"}},
+	}
+
+	tests := []struct {
+		name      string
+		objs      []interface{}
+		namespace *corev1.Namespace
+
+		expectedCluster    string
+		expectedNamespaced string
+		expectedConflicts  [][]Ref
+	}{
+		{
+			name:      "no policies",
+			namespace: namespace,
+		},
+		{
+			name: "empty selectors match everything",
+			objs: []interface{}{
+				namespacedPolicy("default", "p", 0, nil),
+				clusterPolicy("c", 0, nil),
+			},
+			namespace:          namespace,
+			expectedCluster:    "c",
+			expectedNamespaced: "p",
+		},
+		{
+			name: "policies in other namespaces do not match",
+			objs: []interface{}{
+				namespacedPolicy("other", "p", 0, nil),
+			},
+			namespace: namespace,
+		},
+		{
+			name: "pod selector",
+			objs: []interface{}{
+				namespacedPolicy("default", "match", 0, func(spec *v1alpha1.GOMAXPROCSPolicySpec) {
+					spec.PodSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+				}),
+				namespacedPolicy("default", "mismatch", 10, func(spec *v1alpha1.GOMAXPROCSPolicySpec) {
+					spec.PodSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}
+				}),
+			},
+			namespace:          namespace,
+			expectedNamespaced: "match",
+		},
+		{
+			name: "invalid pod selector matches nothing",
+			objs: []interface{}{
+				namespacedPolicy("default", "p", 0, func(spec *v1alpha1.GOMAXPROCSPolicySpec) {
+					spec.PodSelector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Bogus"}}}
+				}),
+			},
+			namespace: namespace,
+		},
+		{
+			name: "container matchers",
+			objs: []interface{}{
+				namespacedPolicy("default", "image", 0, func(spec *v1alpha1.GOMAXPROCSPolicySpec) {
+					spec.Containers = []v1alpha1.ContainerMatcher{{Name: "sidecar"}, {Image: "registry.example.com/*"}}
+				}),
+				namespacedPolicy("default", "name", 10, func(spec *v1alpha1.GOMAXPROCSPolicySpec) {
+					spec.Containers = []v1alpha1.ContainerMatcher{{Name: "app", Image: "docker.io/*"}}
+				}),
+			},
+			namespace:          namespace,
+			expectedNamespaced: "image",
+		},
+		{
+			name: "namespace selector",
+			objs: []interface{}{
+				clusterPolicy("match", 0, func(spec *v1alpha1.ClusterGOMAXPROCSPolicySpec) {
+					spec.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}
+				}),
+				clusterPolicy("mismatch", 10, func(spec *v1alpha1.ClusterGOMAXPROCSPolicySpec) {
+					spec.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"team": "b"}}
+				}),
+			},
+			namespace:       namespace,
+			expectedCluster: "match",
+		},
+		{
+			name: "namespace selector does not match unknown namespace",
+			objs: []interface{}{
+				clusterPolicy("selector", 10, func(spec *v1alpha1.ClusterGOMAXPROCSPolicySpec) {
+					spec.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}
+				}),
+				clusterPolicy("all", 0, nil),
+			},
+			expectedCluster: "all",
+		},
+		{
+			name: "highest priority wins",
+			objs: []interface{}{
+				namespacedPolicy("default", "a", 1, nil),
+				namespacedPolicy("default", "b", 5, nil),
+				namespacedPolicy("default", "c", 3, nil),
+			},
+			namespace:          namespace,
+			expectedNamespaced: "b",
+		},
+		{
+			name: "same priority conflicts",
+			objs: []interface{}{
+				namespacedPolicy("default", "b", 5, nil),
+				namespacedPolicy("default", "a", 5, nil),
+				namespacedPolicy("default", "c", 1, nil),
+				clusterPolicy("y", 0, nil),
+				clusterPolicy("x", 0, nil),
+			},
+			namespace:          namespace,
+			expectedCluster:    "x",
+			expectedNamespaced: "a",
+			expectedConflicts: [][]Ref{
+				{{Name: "x"}, {Name: "y"}},
+				{{Namespace: "default", Name: "a"}, {Namespace: "default", Name: "b"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			match := Resolve(newTestLister(t, test.objs...), pod, test.namespace, container)
+
+			var cluster, namespaced string
+			if match.Cluster != nil {
+				cluster = match.Cluster.Name
+			}
+			if match.Namespaced != nil {
+				namespaced = match.Namespaced.Name
+			}
+			if cluster != test.expectedCluster {
+				t.Errorf("expected cluster policy %q, got %q", test.expectedCluster, cluster)
+			}
+			if namespaced != test.expectedNamespaced {
+				t.Errorf("expected policy %q, got %q", test.expectedNamespaced, namespaced)
+			}
+			if !reflect.DeepEqual(match.Conflicts, test.expectedConflicts) {
+				t.Errorf("expected conflicts %v, got %v", test.expectedConflicts, match.Conflicts)
+			}
+		})
+	}
+}
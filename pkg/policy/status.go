@@ -0,0 +1,192 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gjkim42/gomaxprocs-injector/pkg/apis/gomaxprocs/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+)
+
+// StatusWindow is the window over which the matched pods of a policy are
+// counted.
+const StatusWindow = time.Hour
+
+// Tracker records the pods each policy is applied to and the conflicts between
+// policies, and periodically writes them to the status of the policies.
+//
+// Each replica of the webhook only sees the pods it admits, so the counts are
+// those of the replica that last wrote the status.
+type Tracker struct {
+	client dynamic.Interface
+	lister Lister
+	now    func() time.Time
+
+	mu    sync.Mutex
+	usage map[Ref]*usage
+}
+
+// usage is what a Tracker recorded about a policy.
+type usage struct {
+	// matched counts the pods the policy was applied to per minute.
+	matched map[int64]int64
+	// lastMatched is when the policy was last applied to a pod.
+	lastMatched time.Time
+	// conflicts maps the policies that conflicted with this one to when they
+	// last did.
+	conflicts map[Ref]time.Time
+}
+
+// NewTracker returns a Tracker that writes the status of the policies listed
+// by lister through client.
+func NewTracker(client dynamic.Interface, lister Lister) *Tracker {
+	return &Tracker{
+		client: client,
+		lister: lister,
+		now:    time.Now,
+		usage:  map[Ref]*usage{},
+	}
+}
+
+// Record records a pod the applied policies were applied to, and the
+// conflicting policies that matched its containers.
+func (t *Tracker) Record(applied []Ref, conflicts [][]Ref) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	minute := now.Unix() / 60
+	for _, ref := range applied {
+		u := t.usageOf(ref)
+		u.matched[minute]++
+		u.lastMatched = now
+	}
+	for _, conflict := range conflicts {
+		for _, ref := range conflict {
+			u := t.usageOf(ref)
+			for _, other := range conflict {
+				if other != ref {
+					u.conflicts[other] = now
+				}
+			}
+		}
+	}
+}
+
+func (t *Tracker) usageOf(ref Ref) *usage {
+	u, ok := t.usage[ref]
+	if !ok {
+		u = &usage{
+			matched:   map[int64]int64{},
+			conflicts: map[Ref]time.Time{},
+		}
+		t.usage[ref] = u
+	}
+	return u
+}
+
+// status returns the status of the policy, given its current status, and
+// forgets what fell out of the window.
+func (t *Tracker) status(ref Ref, current v1alpha1.PolicyStatus) v1alpha1.PolicyStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status := v1alpha1.PolicyStatus{
+		WindowSeconds:   int64(StatusWindow / time.Second),
+		LastMatchedTime: current.LastMatchedTime,
+		Conditions:      append([]metav1.Condition(nil), current.Conditions...),
+	}
+
+	now := t.now()
+	if u, ok := t.usage[ref]; ok {
+		oldest := now.Add(-StatusWindow).Unix() / 60
+		for minute, count := range u.matched {
+			if minute <= oldest {
+				delete(u.matched, minute)
+				continue
+			}
+			status.MatchedPods += count
+		}
+		if !u.lastMatched.IsZero() {
+			lastMatched := metav1.NewTime(u.lastMatched.Truncate(time.Second))
+			status.LastMatchedTime = &lastMatched
+		}
+		for other, last := range u.conflicts {
+			if now.Sub(last) > StatusWindow {
+				delete(u.conflicts, other)
+				continue
+			}
+			status.ConflictingPolicies = append(status.ConflictingPolicies, other.String())
+		}
+		sort.Strings(status.ConflictingPolicies)
+	}
+
+	condition := metav1.Condition{
+		Type:    v1alpha1.ConditionConflicting,
+		Status:  metav1.ConditionFalse,
+		Reason:  "NoConflicts",
+		Message: "No policy of the same scope and priority matched the same containers",
+	}
+	if len(status.ConflictingPolicies) > 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "SamePriority"
+		condition.Message = "Policies of the same scope and priority matched the same containers; the first by name is applied"
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+
+	return status
+}
+
+// Run writes the status of the policies every interval until ctx is done.
+func (t *Tracker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.sync(ctx)
+		}
+	}
+}
+
+// sync writes the status of every policy whose status changed.
+func (t *Tracker) sync(ctx context.Context) {
+	for _, p := range t.lister.ClusterPolicies() {
+		ref := Ref{Name: p.Name}
+		t.update(ctx, ref, p.Status, t.client.Resource(v1alpha1.ClusterGOMAXPROCSPolicyResource))
+	}
+
+	for _, p := range t.lister.Policies(metav1.NamespaceAll) {
+		ref := Ref{Namespace: p.Namespace, Name: p.Name}
+		t.update(ctx, ref, p.Status, t.client.Resource(v1alpha1.GOMAXPROCSPolicyResource).Namespace(p.Namespace))
+	}
+}
+
+func (t *Tracker) update(ctx context.Context, ref Ref, current v1alpha1.PolicyStatus, client dynamic.ResourceInterface) {
+	status := t.status(ref, current)
+	if equality.Semantic.DeepEqual(status, current) {
+		return
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{"status": status})
+	if err != nil {
+		klog.ErrorS(err, "Failed to marshal policy status", "policy", ref)
+		return
+	}
+	if _, err := client.Patch(ctx, ref.Name, types.MergePatchType, patch, metav1.PatchOptions{}, "status"); err != nil {
+		klog.ErrorS(err, "Failed to update policy status", "policy", ref)
+		return
+	}
+	klog.V(2).InfoS("Updated policy status", "policy", ref, "matchedPods", status.MatchedPods)
+}
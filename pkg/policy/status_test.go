@@ -0,0 +1,111 @@
+package policy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gjkim42/gomaxprocs-injector/pkg/apis/gomaxprocs/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestTracker(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tracker := NewTracker(nil, nil)
+	tracker.now = func() time.Time { return now }
+
+	a := Ref{Namespace: "default", Name: "a"}
+	b := Ref{Namespace: "default", Name: "b"}
+	c := Ref{Name: "c"}
+
+	tracker.Record([]Ref{a, c}, [][]Ref{{a, b}})
+	now = now.Add(30 * time.Minute)
+	tracker.Record([]Ref{a}, nil)
+
+	status := tracker.status(a, v1alpha1.PolicyStatus{})
+	if status.MatchedPods != 2 {
+		t.Errorf("expected 2 matched pods, got %d", status.MatchedPods)
+	}
+	if status.WindowSeconds != 3600 {
+		t.Errorf("expected a window of 3600 seconds, got %d", status.WindowSeconds)
+	}
+	if status.LastMatchedTime == nil || !status.LastMatchedTime.Time.Equal(now) {
+		t.Errorf("expected last matched time %v, got %v", now, status.LastMatchedTime)
+	}
+	if len(status.ConflictingPolicies) != 1 || status.ConflictingPolicies[0] != b.String() {
+		t.Errorf("expected conflicting policies [%s], got %v", b, status.ConflictingPolicies)
+	}
+	if !meta.IsStatusConditionTrue(status.Conditions, v1alpha1.ConditionConflicting) {
+		t.Errorf("expected Conflicting condition to be true, got %v", status.Conditions)
+	}
+
+	// The first pod and the conflict fall out of the window.
+	now = now.Add(45 * time.Minute)
+	status = tracker.status(a, status)
+	if status.MatchedPods != 1 {
+		t.Errorf("expected 1 matched pod, got %d", status.MatchedPods)
+	}
+	if len(status.ConflictingPolicies) != 0 {
+		t.Errorf("expected no conflicting policies, got %v", status.ConflictingPolicies)
+	}
+	if meta.IsStatusConditionTrue(status.Conditions, v1alpha1.ConditionConflicting) {
+		t.Errorf("expected Conflicting condition to be false, got %v", status.Conditions)
+	}
+
+	status = tracker.status(b, v1alpha1.PolicyStatus{})
+	if status.MatchedPods != 0 || status.LastMatchedTime != nil {
+		t.Errorf("expected policy b to have never matched, got %+v", status)
+	}
+}
+
+func TestTrackerSync(t *testing.T) {
+	scheme := runtime.NewScheme()
+	listKinds := map[schema.GroupVersionResource]string{
+		v1alpha1.GOMAXPROCSPolicyResource:        "GOMAXPROCSPolicyList",
+		v1alpha1.ClusterGOMAXPROCSPolicyResource: "ClusterGOMAXPROCSPolicyList",
+	}
+	policy := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": v1alpha1.SchemeGroupVersion.String(),
+		"kind":       "GOMAXPROCSPolicy",
+		"metadata":   map[string]interface{}{"namespace": "default", "name": "a"},
+		"spec":       map[string]interface{}{"settings": map[string]interface{}{"strategy": "ceil"}},
+	}}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(scheme, listKinds, policy)
+
+	policies, clusterPolicies, err := NewInformers(client, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go policies.Run(ctx.Done())
+	go clusterPolicies.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), policies.HasSynced, clusterPolicies.HasSynced) {
+		t.Fatal("failed to sync informers")
+	}
+
+	lister := NewLister(policies.GetIndexer(), clusterPolicies.GetIndexer())
+	listed := lister.Policies("default")
+	if len(listed) != 1 || listed[0].Spec.Settings.Strategy != "ceil" {
+		t.Fatalf("expected the typed policy to be listed, got %+v", listed)
+	}
+
+	tracker := NewTracker(client, lister)
+	tracker.Record([]Ref{{Namespace: "default", Name: "a"}}, nil)
+	tracker.sync(ctx)
+
+	obj, err := client.Resource(v1alpha1.GOMAXPROCSPolicyResource).Namespace("default").Get(ctx, "a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	matched, _, _ := unstructured.NestedInt64(obj.Object, "status", "matchedPods")
+	if matched != 1 {
+		t.Errorf("expected status.matchedPods to be 1, got %d", matched)
+	}
+}
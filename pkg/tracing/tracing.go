@@ -0,0 +1,64 @@
+// Package tracing sets up the OpenTelemetry tracing of the
+// gomaxprocs-injector.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// ServiceName is the name the gomaxprocs-injector reports its spans under.
+const ServiceName = "gomaxprocs-injector"
+
+// Options configures the export of spans.
+type Options struct {
+	// Endpoint is the host and port of the OTLP/HTTP receiver.
+	Endpoint string
+	// Insecure sends spans over plain HTTP instead of HTTPS.
+	Insecure bool
+	// SamplingRatio is the fraction of the requests without a sampled parent
+	// that are traced. Requests whose parent span is sampled, such as those
+	// of an API server with tracing enabled, are always traced.
+	SamplingRatio float64
+}
+
+// NewTracerProvider returns a TracerProvider that exports spans over OTLP/HTTP
+// to options.Endpoint. The OTEL_EXPORTER_OTLP_* environment variables are
+// honored for settings such as headers and timeouts. The caller must shut the
+// provider down to flush the remaining spans.
+func NewTracerProvider(ctx context.Context, options Options) (*sdktrace.TracerProvider, error) {
+	if options.SamplingRatio < 0 || options.SamplingRatio > 1 {
+		return nil, fmt.Errorf("invalid sampling ratio %v, expected a value between 0 and 1", options.SamplingRatio)
+	}
+
+	exporterOptions := []otlptracehttp.Option{otlptracehttp.WithEndpoint(options.Endpoint)}
+	if options.Insecure {
+		exporterOptions = append(exporterOptions, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, exporterOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithAttributes(semconv.ServiceName(ServiceName)),
+		resource.WithTelemetrySDK(),
+		// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence.
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource: %w", err)
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(options.SamplingRatio))),
+	), nil
+}
@@ -0,0 +1,44 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNewTracerProvider(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		options Options
+		wantErr bool
+	}{
+		{
+			desc:    "valid",
+			options: Options{Endpoint: "localhost:4318", Insecure: true, SamplingRatio: 0.5},
+		},
+		{
+			desc:    "negative sampling ratio",
+			options: Options{Endpoint: "localhost:4318", SamplingRatio: -1},
+			wantErr: true,
+		},
+		{
+			desc:    "sampling ratio greater than 1",
+			options: Options{Endpoint: "localhost:4318", SamplingRatio: 2},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			tp, err := NewTracerProvider(context.Background(), tc.options)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if err := tp.Shutdown(context.Background()); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}